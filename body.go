@@ -0,0 +1,254 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultMaxBodySize is the default limit of the request body read for the cache key
+const DefaultMaxBodySize = 64 << 10
+
+// ErrBodyTooLarge the request body exceeds the limit, the request is not cached
+var ErrBodyTooLarge = errors.New("gin-cache: request body too large")
+
+// BodyCanonicalizer returns the canonical form of body, so that the equivalent bodies share the key
+type BodyCanonicalizer func(body []byte) ([]byte, error)
+
+// WithRequestBodyKey set the limit and the canonicalizer of the request body for CacheByRequestBody.
+// The requests whose body exceeds maxSize, or can't be canonicalized, are not cached.
+func WithRequestBodyKey(maxSize int64, canonicalizer BodyCanonicalizer) Option {
+	return func(c *Config) {
+		if maxSize > 0 {
+			c.maxBodySize = maxSize
+		}
+		if canonicalizer != nil {
+			c.bodyCanonicalizer = canonicalizer
+		}
+	}
+}
+
+// CacheByRequestBody a shortcut function for caching the read-only POST endpoints, e.g. GraphQL or search.
+// The key is made of the method, the path, the sorted query params and the hash of the canonical body.
+// The body is restored, so the handlers can still read it. It's opt-in, never use it for the endpoints
+// with side effects.
+func CacheByRequestBody(defaultCacheStore persist.CacheStore, defaultExpire time.Duration, opts ...Option) gin.HandlerFunc {
+	cfg := newConfigByOpts(opts...)
+
+	if cfg.getCacheStrategyByRequest == nil {
+		keyBuilder := NewKeyBuilder().Method().Path().Query()
+		cfg.getCacheStrategyByRequest = func(c *gin.Context) (bool, Strategy) {
+			bodyHash, err := RequestBodyHash(c, cfg.maxBodySize, cfg.bodyCanonicalizer)
+			if err != nil {
+				logf(c, cfg, LevelDebug, "request body not cacheable", "error", err)
+				return false, Strategy{}
+			}
+
+			return true, Strategy{
+				CacheKey: keyBuilder.Build(c) + keyPartSeparator + "body:" + bodyHash,
+			}
+		}
+	}
+
+	return cache(defaultCacheStore, defaultExpire, cfg)
+}
+
+// RequestBodyHash returns the sha256 of the canonical request body in hex, for the custom strategies.
+// At most maxSize bytes are read, DefaultMaxBodySize if maxSize is not positive, canonicalizer is optional.
+// c.Request.Body is restored in any case.
+func RequestBodyHash(c *gin.Context, maxSize int64, canonicalizer BodyCanonicalizer) (string, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBodySize
+	}
+
+	body, err := readAndRestoreBody(c, maxSize)
+	if err != nil {
+		return "", err
+	}
+
+	if canonicalizer != nil {
+		if body, err = canonicalizer(body); err != nil {
+			return "", err
+		}
+	}
+
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// readAndRestoreBody reads at most maxSize bytes of the body, then puts them back in front of the unread part
+func readAndRestoreBody(c *gin.Context, maxSize int64) ([]byte, error) {
+	req := c.Request
+	if req.Body == nil {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxSize+1))
+	if int64(len(body)) > maxSize {
+		req.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), req.Body), Closer: req.Body}
+		return nil, ErrBodyTooLarge
+	}
+
+	req.Body = readCloser{Reader: bytes.NewReader(body), Closer: req.Body}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// ErrTrailingData the body holds more than one JSON value, the request is not cached
+var ErrTrailingData = errors.New("gin-cache: trailing data after the JSON body")
+
+// CanonicalJSON re-encodes the JSON body with the object keys sorted and without whitespace
+func CanonicalJSON(body []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+
+	var value interface{}
+	if err := decodeJSONBody(body, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// CanonicalGraphQL canonicalizes the GraphQL request {"query", "operationName", "variables"}.
+// The insignificant whitespace, commas and comments of the query are removed,
+// and the empty operation name, variables and extensions are dropped.
+// The other fields, e.g. the id of a persisted query, are kept.
+func CanonicalGraphQL(body []byte) ([]byte, error) {
+	var req map[string]interface{}
+	if err := decodeJSONBody(body, &req); err != nil {
+		return nil, err
+	}
+	if req == nil {
+		req = map[string]interface{}{}
+	}
+
+	if query, ok := req["query"].(string); ok {
+		req["query"] = minifyGraphQL(query)
+	}
+	if name, ok := req["operationName"].(string); ok {
+		if name = strings.TrimSpace(name); name != "" {
+			req["operationName"] = name
+		} else {
+			delete(req, "operationName")
+		}
+	}
+	for _, field := range []string{"operationName", "variables", "extensions"} {
+		if value, ok := req[field]; ok && isEmptyJSON(value) {
+			delete(req, field)
+		}
+	}
+	return json.Marshal(req)
+}
+
+// decodeJSONBody decodes the single JSON value of body into v, the numbers are kept verbatim
+func decodeJSONBody(body []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+
+	var trailing interface{}
+	if err := decoder.Decode(&trailing); err != io.EOF {
+		return ErrTrailingData
+	}
+	return nil
+}
+
+// isEmptyJSON returns whether value is null or an empty object
+func isEmptyJSON(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	object, ok := value.(map[string]interface{})
+	return ok && len(object) == 0
+}
+
+// minifyGraphQL removes the ignored tokens of query, keeping a space only between two names
+func minifyGraphQL(query string) string {
+	var b strings.Builder
+	var last byte
+	pendingSpace := false
+
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == ',':
+			pendingSpace = true
+			continue
+		case ch == '#':
+			for i < len(query) && query[i] != '\n' && query[i] != '\r' {
+				i++
+			}
+			pendingSpace = true
+			continue
+		}
+
+		if pendingSpace && isGraphQLNameChar(last) && isGraphQLNameChar(ch) {
+			b.WriteByte(' ')
+		}
+		pendingSpace = false
+		last = ch
+
+		if ch != '"' {
+			b.WriteByte(ch)
+			continue
+		}
+
+		// copy the string verbatim
+		end := graphQLStringEnd(query, i)
+		b.WriteString(query[i:end])
+		i = end - 1
+	}
+	return b.String()
+}
+
+// graphQLStringEnd returns the index after the string starting at query[start]
+func graphQLStringEnd(query string, start int) int {
+	if strings.HasPrefix(query[start:], `"""`) {
+		for i := start + 3; i < len(query); i++ {
+			if query[i] == '\\' && strings.HasPrefix(query[i:], `\"""`) {
+				i += 3
+				continue
+			}
+			if strings.HasPrefix(query[i:], `"""`) {
+				return i + 3
+			}
+		}
+		return len(query)
+	}
+
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+func isGraphQLNameChar(ch byte) bool {
+	return ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9'
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheByRequestBody(t *testing.T) {
+	calls := 0
+	engine := gin.New()
+	engine.POST("/search",
+		CacheByRequestBody(persist.NewMemoryStore(1*time.Minute), 3*time.Second,
+			WithRequestBodyKey(64, CanonicalJSON),
+		),
+		func(c *gin.Context) {
+			calls++
+			body, err := ioutil.ReadAll(c.Request.Body)
+			require.NoError(t, err)
+			c.String(http.StatusOK, "%d:%s", calls, body)
+		},
+	)
+
+	post := func(body string) string {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(body)))
+		return w.Body.String()
+	}
+
+	assert.Equal(t, `1:{"q": "shoes", "page": 1}`, post(`{"q": "shoes", "page": 1}`))
+	assert.Equal(t, `1:{"q": "shoes", "page": 1}`, post(`{"page":1,"q":"shoes"}`))
+	assert.Equal(t, `2:{"q":"boots"}`, post(`{"q":"boots"}`))
+
+	// the large body is passed to the handler without caching
+	large := `{"q":"` + strings.Repeat("x", 100) + `"}`
+	assert.Equal(t, "3:"+large, post(large))
+	assert.Equal(t, "4:"+large, post(large))
+
+	// invalid json isn't cached either
+	assert.Equal(t, "5:{", post("{"))
+}
+
+func TestCanonicalGraphQL(t *testing.T) {
+	a, err := CanonicalGraphQL([]byte(`{
+		"query": "query User($id: ID!) {\n  user(id: $id) {\n    name, # the display name\n    ... on Admin { level }\n  }\n}",
+		"operationName": "",
+		"variables": {"id": "42"}
+	}`))
+	require.NoError(t, err)
+
+	b, err := CanonicalGraphQL([]byte(`{"variables":{"id":"42"},"query":"query User($id:ID!){user(id:$id){name ...on Admin{level}}}"}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, string(a), string(b))
+	assert.Equal(t, `{"query":"query User($id:ID!){user(id:$id){name...on Admin{level}}}","variables":{"id":"42"}}`, string(a))
+
+	// the strings are kept verbatim
+	c, err := CanonicalGraphQL([]byte(`{"query":"{ search(text: \"a,  b # c\") { id } }"}`))
+	require.NoError(t, err)
+	assert.Equal(t, `{"query":"{search(text:\"a,  b # c\"){id}}"}`, string(c))
+}
+
+func TestCanonicalGraphQLPersistedQuery(t *testing.T) {
+	a, err := CanonicalGraphQL([]byte(`{"id":"query-A","variables":{"id":"42"}}`))
+	require.NoError(t, err)
+	b, err := CanonicalGraphQL([]byte(`{"id":"query-B","variables":{"id":"42"}}`))
+	require.NoError(t, err)
+
+	// the unknown fields are kept, the persisted queries don't share a key
+	assert.NotEqual(t, string(a), string(b))
+	assert.Equal(t, `{"id":"query-A","variables":{"id":"42"}}`, string(a))
+}
+
+func TestCanonicalJSONTrailingData(t *testing.T) {
+	_, err := CanonicalJSON([]byte(`{"q":"a"} {"q":"b"}`))
+	assert.Equal(t, ErrTrailingData, err)
+
+	_, err = CanonicalGraphQL([]byte(`{"query":"{a}"} {"query":"{b}"}`))
+	assert.Equal(t, ErrTrailingData, err)
+
+	canonical, err := CanonicalJSON([]byte(" {\"q\": \"a\"}\n"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"q":"a"}`, string(canonical))
+}
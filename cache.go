@@ -2,19 +2,36 @@ package cache
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/chenyahui/gin-cache/persist"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sync/singleflight"
 )
 
+const distributedLockKeySuffix = ":lock"
+
+var (
+	// ErrSingleFlightLeaderPanic the handlers of the request calling the backend panicked
+	ErrSingleFlightLeaderPanic = errors.New("gin-cache: singleflight leader panicked")
+
+	// ErrSingleFlightLeaderAborted the handlers of the request calling the backend aborted
+	ErrSingleFlightLeaderAborted = errors.New("gin-cache: singleflight leader aborted")
+
+	// ErrSingleFlightLeaderCanceled the client of the request calling the backend has gone
+	ErrSingleFlightLeaderCanceled = errors.New("gin-cache: singleflight leader canceled")
+)
+
 // Strategy the cache strategy
 type Strategy struct {
 	CacheKey string
@@ -24,6 +41,15 @@ type Strategy struct {
 
 	// CacheDuration
 	CacheDuration time.Duration
+
+	// RefreshAheadFactor if greater than zero, overrides the factor of WithRefreshAhead
+	RefreshAheadFactor float64
+
+	// FillLimiter if not nil, overrides the limiter of WithFillLimit
+	FillLimiter *FillLimiter
+
+	// CacheableStatuses if not empty, the statuses of the responses to store instead of 2xx
+	CacheableStatuses []int
 }
 
 // GetCacheStrategyByRequest User can this function to design custom cache strategy by request.
@@ -51,19 +77,47 @@ func cache(
 	}
 
 	sfGroup := singleflight.Group{}
+	refreshingKeys := &sync.Map{}
 
 	return func(c *gin.Context) {
+		start := time.Now()
+
 		shouldCache, cacheStrategy := cfg.getCacheStrategyByRequest(c)
 		if !shouldCache {
+			logf(c, cfg, LevelDebug, "cache bypassed by strategy")
+			emitEvent(c, cfg, Event{Type: EventBypass, Strategy: cacheStrategy, Start: start})
 			c.Next()
 			return
 		}
 
-		cacheKey := cacheStrategy.CacheKey
+		scope := ""
+		err := checkUnkeyedHeaders(c, cfg)
+		if err == nil {
+			scope, err = privateScope(c, cfg)
+		}
+		if err != nil {
+			logf(c, cfg, LevelDebug, "request not cached", "reason", err)
+			c.Next()
+			emitEvent(c, cfg, Event{Type: EventSkipped, Strategy: cacheStrategy, Start: start, Status: c.Writer.Status(), Err: err})
+			return
+		}
+		cacheKey := scope + cacheStrategy.CacheKey
+
+		if len(cfg.namespaces) > 0 || cfg.routeIndex != nil || cfg.privateCache != nil {
+			nsPrefix, err := namespacePrefix(c, cfg)
+			if err != nil {
+				logf(c, cfg, LevelError, "get namespace version error", "error", err)
+				c.Next()
+				return
+			}
+			cacheKey = nsPrefix + cacheKey
+		}
 
 		if cfg.prefixKey != "" {
 			cacheKey = cfg.prefixKey + cacheKey
 		}
+		originalKey := cacheKey
+		cacheKey = hashKey(c, cfg, originalKey)
 
 		// merge cfg
 		cacheStore := defaultCacheStore
@@ -76,20 +130,67 @@ func cache(
 			cacheDuration = cacheStrategy.CacheDuration
 		}
 
-		// read cache first
-		{
+		// stale entries are kept in the store for a while
+		storeDuration := cacheDuration + cfg.maxStale
+
+		fillLimiter := cfg.fillLimiter
+		if cacheStrategy.FillLimiter != nil {
+			fillLimiter = cacheStrategy.FillLimiter
+		}
+
+		cacheStrategy.CacheKey = cacheKey
+		cacheStrategy.CacheStore = cacheStore
+		cacheStrategy.CacheDuration = cacheDuration
+		cacheStrategy.FillLimiter = fillLimiter
+		baseEvent := Event{Key: cacheKey, OriginalKey: originalKey, Strategy: cacheStrategy, Start: start}
+
+		if cfg.logger.Enabled(LevelDebug) {
+			logf(c, cfg, LevelDebug, "cache strategy applied",
+				"key", cacheKey, "store", fmt.Sprintf("%T", cacheStore), "duration", cacheDuration)
+		}
+
+		var spanAttrs []attribute.KeyValue
+		if cfg.tracer != nil {
+			spanAttrs = spanAttributes(cacheStore, cacheKey)
+		}
+
+		// read cache first, unless refreshing ahead
+		var staleCache *ResponseCache
+		if !isRefreshAheadRequest(c) {
+			_, span := startSpan(c, cfg, spanLookup, spanAttrs...)
 			respCache := &ResponseCache{}
 			err := cacheStore.Get(cacheKey, &respCache)
+			if err == nil && isKeyCollision(respCache, originalKey) {
+				logf(c, cfg, LevelWarn, "cache key collision", "key", cacheKey, "original_key", originalKey)
+				err = persist.ErrCacheMiss
+			}
+			if err == nil && isStale(cfg, respCache, cacheDuration) {
+				staleCache = respCache
+				err = persist.ErrCacheMiss
+			}
+
+			span.SetAttributes(attrHit.Bool(err == nil))
+			if err == nil {
+				span.SetAttributes(attrEntrySize.Int(len(respCache.Data)))
+			}
+			endSpan(span, err)
+
 			if err == nil {
+				if shouldRefreshAhead(cfg, cacheStrategy, cacheDuration, respCache) {
+					refreshAhead(c, cfg, refreshingKeys, cacheKey)
+				}
+
+				logf(c, cfg, LevelDebug, "cache hit", "key", cacheKey)
 				replyWithCache(c, cfg, respCache)
-				cfg.hitCacheCallback(c)
+				emitEvent(c, cfg, baseEvent.withResponse(EventHit, respCache))
 				return
 			}
 
 			if !errors.Is(err, persist.ErrCacheMiss) {
-				cfg.logger.Errorf("get cache error: %s, cache key: %s", err, cacheKey)
+				logf(c, cfg, LevelError, "get cache error", "error", err, "key", cacheKey)
 			}
-			cfg.missCacheCallback(c)
+			logf(c, cfg, LevelDebug, "cache miss", "key", cacheKey, "stale", staleCache != nil)
+			emitEvent(c, cfg, baseEvent.withResponse(EventMiss, staleCache))
 		}
 
 		// cache miss, then call the backend
@@ -100,35 +201,295 @@ func cache(
 		}
 		c.Writer = cacheWriter
 
-		inFlight := false
-		rawRespCache, _, _ := sfGroup.Do(cacheKey, func() (interface{}, error) {
+		isLeader := false
+		fromPeer := false
+		sfCtx, sfSpan := startSpan(c, cfg, spanSingleFlight, spanAttrs...)
+		requestBeforeSingleFlight := c.Request
+		if cfg.tracer != nil {
+			c.Request = c.Request.WithContext(sfCtx)
+		}
+		rawResult, _, _ := sfGroup.Do(originalKey, func() (interface{}, error) {
 			if cfg.singleFlightForgetTimeout > 0 {
 				forgetTimer := time.AfterFunc(cfg.singleFlightForgetTimeout, func() {
-					sfGroup.Forget(cacheKey)
+					sfGroup.Forget(originalKey)
 				})
 				defer forgetTimer.Stop()
 			}
 
-			c.Next()
+			if cfg.distributedLock.Locker != nil {
+				unlock, respCache := acquireDistributedLock(c, cfg, cacheStore, cacheKey, originalKey, cacheDuration)
+				if respCache != nil {
+					// another replica has filled the cache
+					fromPeer = true
+					return &singleFlightResult{respCache: respCache}, nil
+				}
+				defer unlock()
+			}
+
+			release, ok := acquireFillLimiter(c, cfg, fillLimiter)
+			if !ok {
+				return &singleFlightResult{err: ErrFillLimitExceeded}, nil
+			}
+			defer release()
 
-			inFlight = true
+			isLeader = true
+			return fillCache(c, cfg, cacheWriter, cacheStore, baseEvent, storeDuration), nil
+		})
+		result := rawResult.(*singleFlightResult)
+		c.Request = requestBeforeSingleFlight
+		sfSpan.SetAttributes(attrShared.Bool(!isLeader))
+		endSpan(sfSpan, result.err)
+
+		switch {
+		case fromPeer:
+			replyWithCache(c, cfg, result.respCache)
+			emitEvent(c, cfg, baseEvent.withResponse(EventHit, result.respCache))
+		case isLeader:
+			// the response has been written by the handlers
+			if result.panicValue != nil {
+				panic(result.panicValue)
+			}
+		case errors.Is(result.err, ErrFillLimitExceeded):
+			logf(c, cfg, LevelWarn, "fill limit exceeded", "key", cacheKey, "stale", staleCache != nil)
+			replyFillLimitExceeded(c, cfg, baseEvent, staleCache)
+		case result.err != nil:
+			// the response of the leader can't be shared
+			if cfg.singleFlightFailureCallback != nil {
+				cfg.singleFlightFailureCallback(c, result.err)
+				return
+			}
 
-			respCache := &ResponseCache{}
-			respCache.fillWithCacheWriter(cacheWriter, cfg)
+			logf(c, cfg, LevelDebug, "singleflight response not shared", "key", cacheKey, "reason", result.err)
 
-			// only cache 2xx response
-			if !c.IsAborted() && cacheWriter.Status() < 300 && cacheWriter.Status() >= 200 {
-				if err := cacheStore.Set(cacheKey, respCache, cacheDuration); err != nil {
-					cfg.logger.Errorf("set cache key error: %s, cache key: %s", err, cacheKey)
-				}
+			release, ok := acquireFillLimiter(c, cfg, fillLimiter)
+			if !ok {
+				logf(c, cfg, LevelWarn, "fill limit exceeded", "key", cacheKey, "stale", staleCache != nil)
+				replyFillLimitExceeded(c, cfg, baseEvent, staleCache)
+				return
 			}
+			ownResult := fillCache(c, cfg, cacheWriter, cacheStore, baseEvent, storeDuration)
+			release()
 
-			return respCache, nil
-		})
+			if ownResult.panicValue != nil {
+				panic(ownResult.panicValue)
+			}
+		default:
+			replyWithCache(c, cfg, result.respCache)
+			emitEvent(c, cfg, baseEvent.withResponse(EventShared, result.respCache))
+		}
+	}
+}
+
+// isStale returns whether the entry is kept only for serving stale, see WithServeStale
+func isStale(cfg *Config, respCache *ResponseCache, cacheDuration time.Duration) bool {
+	return cfg.maxStale > 0 && !respCache.CreatedAt.IsZero() && time.Since(respCache.CreatedAt) >= cacheDuration
+}
+
+// detachedContext keeps the values of parent, but is never canceled with it
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
 
-		if !inFlight {
-			replyWithCache(c, cfg, rawRespCache.(*ResponseCache))
-			cfg.shareSingleFlightCallback(c)
+func (ctx detachedContext) Value(key interface{}) interface{} {
+	return ctx.parent.Value(key)
+}
+
+// singleFlightResult is shared by the requests of the same singleflight
+type singleFlightResult struct {
+	respCache *ResponseCache
+
+	// err is not nil if the response is only meaningful to the leader
+	err        error
+	panicValue interface{}
+}
+
+// fillCache call the backend, and store the response if it's cacheable.
+// The panic of the handlers is recovered, so that it doesn't spread to the other requests of the singleflight.
+func fillCache(
+	c *gin.Context,
+	cfg *Config,
+	cacheWriter *responseCacheWriter,
+	cacheStore persist.CacheStore,
+	baseEvent Event,
+	storeDuration time.Duration,
+) (result *singleFlightResult) {
+	result = &singleFlightResult{}
+	cacheKey := baseEvent.Key
+
+	if cfg.detachedTimeout > 0 {
+		originalRequest := c.Request
+		ctx, cancel := context.WithTimeout(detachedContext{parent: originalRequest.Context()}, cfg.detachedTimeout)
+		c.Request = originalRequest.WithContext(ctx)
+		defer func() {
+			cancel()
+			c.Request = originalRequest
+		}()
+	}
+
+	var spanAttrs []attribute.KeyValue
+	if cfg.tracer != nil {
+		spanAttrs = spanAttributes(cacheStore, cacheKey)
+	}
+
+	// the fill span is the parent of the spans started by the handlers
+	ctx, span := startSpan(c, cfg, spanFill, spanAttrs...)
+	defer func() {
+		endSpan(span, result.err)
+	}()
+	if cfg.tracer != nil {
+		requestBeforeFill := c.Request
+		c.Request = requestBeforeFill.WithContext(ctx)
+		defer func() {
+			c.Request = requestBeforeFill
+		}()
+	}
+
+	// registered last, so that the deferred functions above see the panic
+	defer func() {
+		if r := recover(); r != nil {
+			result.err = ErrSingleFlightLeaderPanic
+			result.panicValue = r
+		}
+	}()
+
+	c.Next()
+
+	respCache := &ResponseCache{}
+	respCache.fillWithCacheWriter(cacheWriter, cfg)
+	if cfg.keyHasher != nil {
+		respCache.Key = baseEvent.OriginalKey
+	}
+	result.respCache = respCache
+	span.SetAttributes(attrStatus.Int(respCache.Status), attrEntrySize.Int(len(respCache.Data)))
+
+	switch {
+	case c.IsAborted():
+		result.err = ErrSingleFlightLeaderAborted
+	case c.Request.Context().Err() != nil:
+		// the client has gone or the detached context timed out, the response may be incomplete
+		result.err = ErrSingleFlightLeaderCanceled
+	default:
+		result.err = checkReflectedHeaders(c, cfg, cacheKey, respCache)
+		if result.err == nil {
+			result.err = applyCacheabilityPolicy(cfg, cacheWriter, respCache)
+		}
+	}
+
+	// only cache 2xx response by default
+	if result.err == nil && isCacheableStatus(baseEvent.Strategy, cacheWriter.Status()) {
+		_, setSpan := startSpan(c, cfg, spanStoreSet, append(spanAttrs, attrEntrySize.Int(len(respCache.Data)))...)
+		err := cacheStore.Set(cacheKey, respCache, storeDuration)
+		endSpan(setSpan, err)
+
+		if err != nil {
+			logf(c, cfg, LevelError, "set cache error", "error", err, "key", cacheKey)
+
+			event := baseEvent.withResponse(EventStoreFailed, respCache)
+			event.Err = err
+			emitEvent(c, cfg, event)
+		} else {
+			logf(c, cfg, LevelDebug, "response stored",
+				"key", cacheKey, "status", respCache.Status, "size", len(respCache.Data), "expire", storeDuration)
+			event := baseEvent.withResponse(EventStored, respCache)
+			event.Expire = storeDuration
+			emitEvent(c, cfg, event)
+		}
+	} else {
+		span.SetAttributes(attrStoreSkipped.Bool(true))
+
+		reason := result.err
+		if reason == nil {
+			reason = ErrStatusNotCacheable
+		}
+		logf(c, cfg, LevelDebug, "response not stored", "key", cacheKey, "status", respCache.Status, "reason", reason)
+
+		event := baseEvent.withResponse(EventSkipped, respCache)
+		event.Err = reason
+		emitEvent(c, cfg, event)
+	}
+
+	return result
+}
+
+// isCacheableStatus returns whether the response of status is stored under strategy
+func isCacheableStatus(strategy Strategy, status int) bool {
+	if len(strategy.CacheableStatuses) > 0 {
+		return containsInt(strategy.CacheableStatuses, status)
+	}
+	return status >= 200 && status < 300
+}
+
+func containsInt(values []int, n int) bool {
+	for _, v := range values {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// acquireDistributedLock try to become the replica which calls the backend.
+// If another replica holds the lock, wait for its response appearing in the cache store.
+// When the wait times out, the caller calls the backend locally as well.
+func acquireDistributedLock(
+	c *gin.Context,
+	cfg *Config,
+	cacheStore persist.CacheStore,
+	cacheKey string,
+	originalKey string,
+	cacheDuration time.Duration,
+) (unlock func(), respCache *ResponseCache) {
+	lock := cfg.distributedLock
+	lockKey := cacheKey + distributedLockKeySuffix
+	noop := func() {}
+
+	token, acquired, err := lock.Locker.TryLock(lockKey, lock.LockTTL)
+	if err != nil {
+		logf(c, cfg, LevelError, "acquire distributed lock error", "error", err, "key", cacheKey)
+		return noop, nil
+	}
+
+	if acquired {
+		return func() {
+			if err := lock.Locker.Unlock(lockKey, token); err != nil {
+				logf(c, cfg, LevelError, "release distributed lock error", "error", err, "key", cacheKey)
+			}
+		}, nil
+	}
+
+	timeout := time.NewTimer(lock.WaitTimeout)
+	defer timeout.Stop()
+
+	ticker := time.NewTicker(lock.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			respCache := &ResponseCache{}
+			err := cacheStore.Get(cacheKey, &respCache)
+			if err == nil && !isKeyCollision(respCache, originalKey) && !isStale(cfg, respCache, cacheDuration) {
+				return noop, respCache
+			}
+			if !errors.Is(err, persist.ErrCacheMiss) {
+				logf(c, cfg, LevelError, "get cache error", "error", err, "key", cacheKey)
+			}
+		case <-timeout.C:
+			return noop, nil
+		case <-c.Request.Context().Done():
+			return noop, nil
 		}
 	}
 }
@@ -142,11 +503,11 @@ func CacheByRequestURI(defaultCacheStore persist.CacheStore, defaultExpire time.
 	}
 
 	var cacheStrategy GetCacheStrategyByRequest
-	if cfg.ignoreQueryOrder {
+	if cfg.ignoreQueryOrder || cfg.queryNormalization != nil {
 		cacheStrategy = func(c *gin.Context) (bool, Strategy) {
-			newUri, err := getRequestUriIgnoreQueryOrder(c.Request.RequestURI)
+			newUri, err := getRequestUriIgnoreQueryOrder(c.Request.RequestURI, cfg.queryNormalization)
 			if err != nil {
-				cfg.logger.Errorf("getRequestUriIgnoreQueryOrder error: %s", err)
+				logf(c, cfg, LevelError, "getRequestUriIgnoreQueryOrder error", "error", err, "uri", c.Request.RequestURI)
 				newUri = c.Request.RequestURI
 			}
 
@@ -168,13 +529,21 @@ func CacheByRequestURI(defaultCacheStore persist.CacheStore, defaultExpire time.
 	return cache(defaultCacheStore, defaultExpire, cfg)
 }
 
-func getRequestUriIgnoreQueryOrder(requestURI string) (string, error) {
+// getRequestUriIgnoreQueryOrder sorts the query params of requestURI, and normalizes them if normalization is not nil
+func getRequestUriIgnoreQueryOrder(requestURI string, normalization *QueryNormalization) (string, error) {
 	parsedUrl, err := url.ParseRequestURI(requestURI)
 	if err != nil {
 		return "", err
 	}
 
 	values := parsedUrl.Query()
+	if normalization != nil {
+		values = normalization.normalize(values)
+		if len(values) == 0 {
+			return parsedUrl.Path, nil
+		}
+		return parsedUrl.Path + "?" + encodeKeyValues(values), nil
+	}
 
 	if len(values) == 0 {
 		return requestURI, nil
@@ -217,15 +586,30 @@ type ResponseCache struct {
 	Status int
 	Header http.Header
 	Data   []byte
+
+	// CreatedAt when the response is generated by the backend
+	CreatedAt time.Time
+
+	// Key is the original cache key, it's only stored with WithHashedKey
+	Key string
+}
+
+// Size returns the size of the body, it implements persist.Sizer
+func (c *ResponseCache) Size() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.Data)
 }
 
 func (c *ResponseCache) fillWithCacheWriter(cacheWriter *responseCacheWriter, cfg *Config) {
+	c.CreatedAt = time.Now()
 	c.Status = cacheWriter.Status()
 	c.Data = cacheWriter.body.Bytes()
 	if !cfg.withoutHeader {
 		c.Header = cacheWriter.Header().Clone()
 
-		for _, headerKey := range cfg.discardHeaders {
+		for headerKey := range cfg.perRequestHeaders {
 			c.Header.Del(headerKey)
 		}
 	}
@@ -259,14 +643,21 @@ func replyWithCache(
 
 	if !cfg.withoutHeader {
 		for key, values := range respCache.Header {
+			if _, ok := cfg.perRequestHeaders[key]; ok {
+				continue
+			}
 			for _, val := range values {
 				c.Writer.Header().Set(key, val)
 			}
 		}
 	}
 
+	if cfg.regenerateHeadersFunc != nil {
+		cfg.regenerateHeadersFunc(c)
+	}
+
 	if _, err := c.Writer.Write(respCache.Data); err != nil {
-		cfg.logger.Errorf("write response error: %s", err)
+		logf(c, cfg, LevelError, "write response error", "error", err)
 	}
 
 	// abort handler chain and return directly
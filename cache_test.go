@@ -1,10 +1,13 @@
 package cache
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -190,11 +193,11 @@ func TestWriteHeader(t *testing.T) {
 }
 
 func TestGetRequestUriIgnoreQueryOrder(t *testing.T) {
-	val, err := getRequestUriIgnoreQueryOrder("/test?c=3&b=2&a=1")
+	val, err := getRequestUriIgnoreQueryOrder("/test?c=3&b=2&a=1", nil)
 	require.NoError(t, err)
 	assert.Equal(t, "/test?a=1&b=2&c=3", val)
 
-	val, err = getRequestUriIgnoreQueryOrder("/test?d=4&e=5")
+	val, err = getRequestUriIgnoreQueryOrder("/test?d=4&e=5", nil)
 	require.NoError(t, err)
 	assert.Equal(t, "/test?d=4&e=5", val)
 }
@@ -318,3 +321,314 @@ func TestCacheByRequestURICustomCacheStrategy(t *testing.T) {
 	w3 := mockHttpRequest(cacheURIMiddleware, "/cache?uid=u1", true)
 	assert.NotEqual(t, w1.Body, w3.Body)
 }
+
+func TestCacheByRequestURIWithDiskStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gin-cache-disk")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	diskStore, err := persist.NewDiskStore(dir)
+	require.NoError(t, err)
+	defer diskStore.Close()
+
+	cacheURIMiddleware := CacheByRequestURI(diskStore, 3*time.Second)
+
+	w1 := mockHttpRequest(cacheURIMiddleware, "/cache?uid=u1", true)
+	w2 := mockHttpRequest(cacheURIMiddleware, "/cache?uid=u1", true)
+	w3 := mockHttpRequest(cacheURIMiddleware, "/cache?uid=u2", true)
+
+	assert.Equal(t, w1.Body, w2.Body)
+	assert.Equal(t, w1.Code, w2.Code)
+	assert.Equal(t, w1.Header(), w2.Header())
+	assert.NotEqual(t, w2.Body, w3.Body)
+}
+
+func TestDistributedLock(t *testing.T) {
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+
+	var backendCount int32
+	newReplica := func(handlerDelay time.Duration, status int) *gin.Engine {
+		_, engine := gin.CreateTestContext(httptest.NewRecorder())
+		engine.GET("/cache",
+			CacheByRequestURI(memoryStore, 3*time.Second, WithDistributedLock(DistributedLock{
+				Locker:       memoryStore,
+				WaitTimeout:  500 * time.Millisecond,
+				PollInterval: 10 * time.Millisecond,
+			})),
+			func(c *gin.Context) {
+				atomic.AddInt32(&backendCount, 1)
+				time.Sleep(handlerDelay)
+				c.String(status, fmt.Sprintf("rand:%d", rand.Int()))
+			},
+		)
+		return engine
+	}
+
+	serve := func(engine *gin.Engine, url string) *httptest.ResponseRecorder {
+		testWriter := httptest.NewRecorder()
+		engine.ServeHTTP(testWriter, httptest.NewRequest(http.MethodGet, url, nil))
+		return testWriter
+	}
+
+	{
+		replica1 := newReplica(200*time.Millisecond, http.StatusOK)
+		replica2 := newReplica(0, http.StatusOK)
+
+		var w1 *httptest.ResponseRecorder
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w1 = serve(replica1, "/cache?uid=1")
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		w2 := serve(replica2, "/cache?uid=1")
+		wg.Wait()
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&backendCount))
+		assert.Equal(t, w1.Body.String(), w2.Body.String())
+	}
+
+	// the lock holder doesn't fill the cache, the waiting replica falls back to its own backend
+	{
+		atomic.StoreInt32(&backendCount, 0)
+		replica1 := newReplica(800*time.Millisecond, http.StatusInternalServerError)
+		replica2 := newReplica(0, http.StatusOK)
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve(replica1, "/cache?uid=2")
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		w2 := serve(replica2, "/cache?uid=2")
+		wg.Wait()
+
+		assert.Equal(t, int32(2), atomic.LoadInt32(&backendCount))
+		assert.Equal(t, http.StatusOK, w2.Code)
+	}
+}
+
+// mockSingleFlight sends a leader request, then followers joining its singleflight while the leader is in the backend
+func mockSingleFlight(engine *gin.Engine, leaderReq *http.Request, followerCount int) (*httptest.ResponseRecorder, []*httptest.ResponseRecorder) {
+	leader := httptest.NewRecorder()
+	followers := make([]*httptest.ResponseRecorder, followerCount)
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		engine.ServeHTTP(leader, leaderReq)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	for i := range followers {
+		wg.Add(1)
+		followers[i] = httptest.NewRecorder()
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache", nil))
+		}(followers[i])
+	}
+
+	wg.Wait()
+	return leader, followers
+}
+
+func newSingleFlightEngine(firstCall gin.HandlerFunc, opts ...Option) *gin.Engine {
+	engine := gin.New()
+	engine.Use(gin.CustomRecovery(func(c *gin.Context, _ interface{}) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+
+	var callCount int32
+	engine.GET("/cache",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second, opts...),
+		func(c *gin.Context) {
+			if atomic.AddInt32(&callCount, 1) == 1 {
+				time.Sleep(200 * time.Millisecond)
+				firstCall(c)
+				return
+			}
+			c.String(http.StatusOK, "ok")
+		},
+	)
+	return engine
+}
+
+func TestSingleFlightLeaderPanic(t *testing.T) {
+	engine := newSingleFlightEngine(func(c *gin.Context) {
+		panic("leader panic")
+	})
+
+	leader, followers := mockSingleFlight(engine, httptest.NewRequest(http.MethodGet, "/cache", nil), 3)
+	assert.Equal(t, http.StatusInternalServerError, leader.Code)
+
+	// followers call their own handlers instead
+	for _, follower := range followers {
+		assert.Equal(t, http.StatusOK, follower.Code)
+		assert.Equal(t, "ok", follower.Body.String())
+	}
+}
+
+func TestSingleFlightLeaderAbort(t *testing.T) {
+	engine := newSingleFlightEngine(func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusUnauthorized)
+	})
+
+	leader, followers := mockSingleFlight(engine, httptest.NewRequest(http.MethodGet, "/cache", nil), 3)
+	assert.Equal(t, http.StatusUnauthorized, leader.Code)
+
+	for _, follower := range followers {
+		assert.Equal(t, http.StatusOK, follower.Code)
+		assert.Equal(t, "ok", follower.Body.String())
+	}
+}
+
+func TestSingleFlightLeaderCanceled(t *testing.T) {
+	var failures int32
+	engine := newSingleFlightEngine(
+		func(c *gin.Context) {
+			c.String(http.StatusOK, "partial")
+		},
+		WithOnSingleFlightFailure(func(c *gin.Context, err error) {
+			assert.Equal(t, ErrSingleFlightLeaderCanceled, err)
+			atomic.AddInt32(&failures, 1)
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+		}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	leaderReq := httptest.NewRequest(http.MethodGet, "/cache", nil).WithContext(ctx)
+
+	_, followers := mockSingleFlight(engine, leaderReq, 3)
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&failures))
+	for _, follower := range followers {
+		assert.Equal(t, http.StatusServiceUnavailable, follower.Code)
+	}
+
+	// the response of the canceled leader isn't cached
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache", nil))
+	assert.Equal(t, "ok", w.Body.String())
+}
+
+func TestPerRequestHeaders(t *testing.T) {
+	var requestID, handlerID int32
+
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Header("X-Request-Id", fmt.Sprintf("request-%d", atomic.AddInt32(&requestID, 1)))
+	})
+	engine.GET("/cache",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second,
+			WithPerRequestHeaders(append(PerRequestHeaders(), "X-Handler-Id"), func(c *gin.Context) {
+				c.Header("X-Handler-Id", "regenerated")
+			}),
+		),
+		func(c *gin.Context) {
+			time.Sleep(100 * time.Millisecond)
+			c.Header("X-Request-Id", "overwritten-by-handler")
+			c.Header("X-Handler-Id", fmt.Sprintf("handler-%d", atomic.AddInt32(&handlerID, 1)))
+			c.Header("X-Shared", "shared")
+			c.String(http.StatusOK, "ok")
+		},
+	)
+
+	leader, followers := mockSingleFlight(engine, httptest.NewRequest(http.MethodGet, "/cache", nil), 3)
+	assert.Equal(t, "handler-1", leader.Header().Get("X-Handler-Id"))
+
+	hit := httptest.NewRecorder()
+	engine.ServeHTTP(hit, httptest.NewRequest(http.MethodGet, "/cache", nil))
+
+	requestIDs := map[string]bool{}
+	for _, w := range append(followers, hit) {
+		assert.Equal(t, "ok", w.Body.String())
+		assert.Equal(t, "shared", w.Header().Get("X-Shared"))
+		assert.Equal(t, "regenerated", w.Header().Get("X-Handler-Id"))
+		requestIDs[w.Header().Get("X-Request-Id")] = true
+	}
+
+	// every request keeps its own request id
+	assert.Len(t, requestIDs, 4)
+	assert.False(t, requestIDs["overwritten-by-handler"])
+}
+
+func TestPerRequestHeadersSetCookie(t *testing.T) {
+	var calls int32
+
+	engine := gin.New()
+	engine.GET("/cache",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second,
+			WithPerRequestHeaders(PerRequestHeaders(), nil),
+			WithCacheabilityPolicy(CacheabilityPolicy{SetCookie: CacheabilityStore}),
+		),
+		func(c *gin.Context) {
+			c.SetCookie("session", fmt.Sprintf("user-%d", atomic.AddInt32(&calls, 1)), 0, "/", "", false, true)
+			c.String(http.StatusOK, "ok")
+		},
+	)
+
+	first := httptest.NewRecorder()
+	engine.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/cache", nil))
+	assert.Contains(t, first.Header().Get("Set-Cookie"), "session=user-1")
+
+	// the response is cached without the cookie of the first user
+	hit := httptest.NewRecorder()
+	engine.ServeHTTP(hit, httptest.NewRequest(http.MethodGet, "/cache", nil))
+	assert.Equal(t, "ok", hit.Body.String())
+	assert.Empty(t, hit.Header().Get("Set-Cookie"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestDetachedContext(t *testing.T) {
+	firstCall := func(c *gin.Context) {
+		if err := c.Request.Context().Err(); err != nil {
+			c.AbortWithStatus(http.StatusGatewayTimeout)
+			return
+		}
+		c.String(http.StatusOK, "leader")
+	}
+
+	// the leader's client goes away, but followers still share the leader's response
+	{
+		engine := newSingleFlightEngine(firstCall, WithDetachedContext(1*time.Second))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		leaderReq := httptest.NewRequest(http.MethodGet, "/cache", nil).WithContext(ctx)
+
+		_, followers := mockSingleFlight(engine, leaderReq, 3)
+		for _, follower := range followers {
+			assert.Equal(t, "leader", follower.Body.String())
+		}
+	}
+
+	// the detached context is still bounded by its own timeout
+	{
+		engine := newSingleFlightEngine(firstCall, WithDetachedContext(100*time.Millisecond))
+
+		leader, followers := mockSingleFlight(engine, httptest.NewRequest(http.MethodGet, "/cache", nil), 3)
+		assert.Equal(t, http.StatusGatewayTimeout, leader.Code)
+		for _, follower := range followers {
+			assert.Equal(t, "ok", follower.Body.String())
+		}
+	}
+}
+
+func TestDetachedContextValue(t *testing.T) {
+	type ctxKey struct{}
+
+	parent, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "value"))
+	cancel()
+
+	ctx := detachedContext{parent: parent}
+	assert.Nil(t, ctx.Err())
+	assert.Nil(t, ctx.Done())
+	assert.Equal(t, "value", ctx.Value(ctxKey{}))
+}
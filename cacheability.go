@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrResponseSetsCookie the response sets cookies, it's not stored by default, see CacheabilityPolicy
+	ErrResponseSetsCookie = errors.New("gin-cache: response with set-cookie not cached")
+
+	// ErrResponseAuthenticate the response challenges the client, it's not stored by default, see CacheabilityPolicy
+	ErrResponseAuthenticate = errors.New("gin-cache: response with www-authenticate not cached")
+
+	// ErrResponsePrivate the response is marked private or no-store, it's not stored by default, see CacheabilityPolicy
+	ErrResponsePrivate = errors.New("gin-cache: response with cache-control private not cached")
+)
+
+// CacheabilityAction is what to do with a request or a response matching a rule of CacheabilityPolicy
+type CacheabilityAction int
+
+const (
+	// CacheabilitySkip neither stores nor shares the response, it's the default of every rule
+	CacheabilitySkip CacheabilityAction = iota
+	// CacheabilityStrip removes the matched header from the cache, then stores the response
+	CacheabilityStrip
+	// CacheabilityStore stores the response as is
+	CacheabilityStore
+)
+
+// CacheabilityPolicy decides whether a response carrying user or session state is cached.
+// The zero value is the default policy, which skips all of them.
+type CacheabilityPolicy struct {
+	// SetCookie applies to the responses with Set-Cookie
+	SetCookie CacheabilityAction
+
+	// WWWAuthenticate applies to the responses with WWW-Authenticate
+	WWWAuthenticate CacheabilityAction
+
+	// CacheControlPrivate applies to the responses with Cache-Control private or no-store,
+	// CacheabilityStrip removes the Cache-Control header
+	CacheControlPrivate CacheabilityAction
+
+	// Authorization applies to the requests with Authorization not scoped by WithPrivateCache.
+	// CacheabilityStore caches them publicly, for the endpoints whose response doesn't depend on the user.
+	// CacheabilityStrip is the same as CacheabilitySkip.
+	Authorization CacheabilityAction
+}
+
+// WithCacheabilityPolicy set the policy of the responses carrying user or session state
+func WithCacheabilityPolicy(policy CacheabilityPolicy) Option {
+	return func(c *Config) {
+		c.cacheabilityPolicy = policy
+	}
+}
+
+// applyCacheabilityPolicy checks the response of cacheWriter against cfg.cacheabilityPolicy,
+// the matched headers are removed from respCache if the rule strips them.
+func applyCacheabilityPolicy(cfg *Config, cacheWriter *responseCacheWriter, respCache *ResponseCache) error {
+	policy := cfg.cacheabilityPolicy
+	header := cacheWriter.Header()
+
+	rules := []struct {
+		header  string
+		matched bool
+		action  CacheabilityAction
+		err     error
+	}{
+		{"Set-Cookie", len(header["Set-Cookie"]) > 0, policy.SetCookie, ErrResponseSetsCookie},
+		{"Www-Authenticate", len(header["Www-Authenticate"]) > 0, policy.WWWAuthenticate, ErrResponseAuthenticate},
+		{"Cache-Control", isPrivateCacheControl(header), policy.CacheControlPrivate, ErrResponsePrivate},
+	}
+
+	for _, rule := range rules {
+		if !rule.matched {
+			continue
+		}
+
+		switch rule.action {
+		case CacheabilityStore:
+		case CacheabilityStrip:
+			respCache.Header.Del(rule.header)
+		default:
+			return rule.err
+		}
+	}
+	return nil
+}
+
+// isPrivateCacheControl returns whether Cache-Control has the private or no-store directive
+func isPrivateCacheControl(header http.Header) bool {
+	for _, value := range header["Cache-Control"] {
+		for _, directive := range strings.Split(value, ",") {
+			name := strings.TrimSpace(directive)
+			if i := strings.IndexByte(name, '='); i >= 0 {
+				name = name[:i]
+			}
+
+			if strings.EqualFold(name, "private") || strings.EqualFold(name, "no-store") {
+				return true
+			}
+		}
+	}
+	return false
+}
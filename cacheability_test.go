@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheabilityPolicyDefault(t *testing.T) {
+	headers := map[string]string{
+		"Set-Cookie":       "session=secret",
+		"WWW-Authenticate": `Bearer realm="api"`,
+		"Cache-Control":    `private="Set-Cookie", max-age=60`,
+	}
+
+	for header, value := range headers {
+		calls := 0
+		recorder := &eventRecorder{}
+		engine := gin.New()
+		engine.GET("/cache",
+			CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second, WithEventHandler(recorder)),
+			func(c *gin.Context) {
+				calls++
+				c.Header(header, value)
+				c.String(http.StatusOK, "value")
+			},
+		)
+
+		for i := 0; i < 2; i++ {
+			engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cache", nil))
+		}
+		assert.Equal(t, 2, calls, header)
+		assert.Equal(t, EventSkipped, recorder.last().Type, header)
+	}
+}
+
+func TestCacheabilityPolicyNoStore(t *testing.T) {
+	calls := 0
+	engine := gin.New()
+	engine.GET("/cache", CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second), func(c *gin.Context) {
+		calls++
+		c.Header("Cache-Control", "No-Store")
+		c.String(http.StatusOK, "value")
+	})
+
+	for i := 0; i < 2; i++ {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cache", nil))
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestCacheabilityPolicyStrip(t *testing.T) {
+	calls := 0
+	engine := gin.New()
+	engine.GET("/cache",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second,
+			WithCacheabilityPolicy(CacheabilityPolicy{SetCookie: CacheabilityStrip})),
+		func(c *gin.Context) {
+			calls++
+			c.SetCookie("session", "secret", 60, "/", "", false, true)
+			c.Header("X-Custom", "kept")
+			c.String(http.StatusOK, "value")
+		},
+	)
+
+	first := httptest.NewRecorder()
+	engine.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/cache", nil))
+	assert.Contains(t, first.Header().Get("Set-Cookie"), "session=secret")
+
+	second := httptest.NewRecorder()
+	engine.ServeHTTP(second, httptest.NewRequest(http.MethodGet, "/cache", nil))
+	assert.Equal(t, 1, calls)
+	assert.Empty(t, second.Header().Get("Set-Cookie"))
+	assert.Equal(t, "kept", second.Header().Get("X-Custom"))
+}
+
+func TestCacheabilityPolicyStore(t *testing.T) {
+	calls := 0
+	engine := gin.New()
+	engine.GET("/cache",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second,
+			WithCacheabilityPolicy(CacheabilityPolicy{SetCookie: CacheabilityStore})),
+		func(c *gin.Context) {
+			calls++
+			c.Header("Set-Cookie", "theme=dark")
+			c.String(http.StatusOK, "value")
+		},
+	)
+
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cache", nil))
+	second := httptest.NewRecorder()
+	engine.ServeHTTP(second, httptest.NewRequest(http.MethodGet, "/cache", nil))
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "theme=dark", second.Header().Get("Set-Cookie"))
+}
+
+func TestCacheabilityPolicyAuthorization(t *testing.T) {
+	calls := 0
+	engine := gin.New()
+	engine.GET("/cache",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second,
+			WithCacheabilityPolicy(CacheabilityPolicy{Authorization: CacheabilityStore})),
+		func(c *gin.Context) {
+			calls++
+			c.String(http.StatusOK, "value")
+		},
+	)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/cache", nil)
+		req.Header.Set("Authorization", "Bearer token")
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"errors"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrStatusNotCacheable the response is not stored because its status is not cacheable, 2xx by default
+var ErrStatusNotCacheable = errors.New("gin-cache: response status not cacheable")
+
+// EventType is the kind of Event
+type EventType int
+
+const (
+	// EventHit the request is replied with the cache, including the entry filled by another replica
+	EventHit EventType = iota + 1
+	// EventMiss the entry doesn't exist or is stale
+	EventMiss
+	// EventBypass the strategy decides not to cache the request
+	EventBypass
+	// EventShared the request is replied with the response of the singleflight leader
+	EventShared
+	// EventStored the response is written to the cache store
+	EventStored
+	// EventStoreFailed the cache store failed to write the response, Err is the error of the store
+	EventStoreFailed
+	// EventSkipped the response is not stored, Err is the reason, e.g. ErrStatusNotCacheable
+	EventSkipped
+	// EventStaleServed the request is replied with the stale entry because the fill limit is exceeded
+	EventStaleServed
+	// EventEvicted the store removed the entry on expiration or capacity, see NotifyEvictions
+	EventEvicted
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventHit:
+		return "hit"
+	case EventMiss:
+		return "miss"
+	case EventBypass:
+		return "bypass"
+	case EventShared:
+		return "shared"
+	case EventStored:
+		return "stored"
+	case EventStoreFailed:
+		return "store_failed"
+	case EventSkipped:
+		return "skipped"
+	case EventStaleServed:
+		return "stale_served"
+	case EventEvicted:
+		return "evicted"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describe what the middleware did with a request
+type Event struct {
+	Type EventType
+
+	// Key is the cache key with the prefix, empty for EventBypass
+	Key string
+
+	// OriginalKey is the key before hashed by WithHashedKey, the same as Key without it
+	OriginalKey string
+
+	// Strategy is the strategy of the request, merged with the default store and duration
+	Strategy Strategy
+
+	// Status and Size describe the response, they are zero if no response is involved
+	Status int
+	Size   int
+
+	// Expire is how long the entry is kept in the store for EventStored, including the stale period of WithServeStale
+	Expire time.Duration
+
+	// Start is when the middleware received the request, Latency is the time elapsed since then
+	Start   time.Time
+	Latency time.Duration
+
+	// Err is set for EventStoreFailed and EventSkipped
+	Err error
+}
+
+// EventHandler observes the events of the middleware, e.g. for metrics, logging or auditing.
+// It's called synchronously in the request goroutine, so it should return quickly.
+// c is nil for EventEvicted.
+type EventHandler interface {
+	HandleEvent(c *gin.Context, event Event)
+}
+
+// EventHandlerFunc adapts a function to EventHandler
+type EventHandlerFunc func(c *gin.Context, event Event)
+
+// HandleEvent calls f(c, event)
+func (f EventHandlerFunc) HandleEvent(c *gin.Context, event Event) {
+	f(c, event)
+}
+
+// WithEventHandler add an observer of all the events, it can be used multiple times.
+// The callbacks of WithOnHitCache, WithOnMissCache, WithOnBypassCache and WithOnShareSingleFlight
+// are still called for the corresponding events.
+func WithEventHandler(handler EventHandler) Option {
+	return func(c *Config) {
+		if handler != nil {
+			c.eventHandlers = append(c.eventHandlers, handler)
+		}
+	}
+}
+
+// NotifyEvictions report the entries evicted by store to handler as EventEvicted.
+// It returns false if store doesn't report its evictions, see persist.EvictionNotifier.
+// Call it once per store rather than per middleware, since a store is usually shared by routes.
+func NotifyEvictions(store persist.CacheStore, handler EventHandler) bool {
+	notifier, ok := store.(persist.EvictionNotifier)
+	if !ok || handler == nil {
+		return false
+	}
+
+	notifier.OnEvicted(func(key string) {
+		handler.HandleEvent(nil, Event{Type: EventEvicted, Key: key})
+	})
+	return true
+}
+
+// emitEvent call the legacy callback of event, then the event handlers
+func emitEvent(c *gin.Context, cfg *Config, event Event) {
+	switch event.Type {
+	case EventHit:
+		cfg.hitCacheCallback(c)
+	case EventMiss:
+		cfg.missCacheCallback(c)
+	case EventBypass:
+		cfg.bypassCacheCallback(c)
+	case EventShared:
+		cfg.shareSingleFlightCallback(c)
+	}
+
+	if len(cfg.eventHandlers) == 0 {
+		return
+	}
+
+	if !event.Start.IsZero() {
+		event.Latency = time.Since(event.Start)
+	}
+	for _, handler := range cfg.eventHandlers {
+		handler.HandleEvent(c, event)
+	}
+}
+
+// withResponse returns a copy of event with the type and the response
+func (e Event) withResponse(eventType EventType, respCache *ResponseCache) Event {
+	e.Type = eventType
+	if respCache != nil {
+		e.Status = respCache.Status
+		e.Size = len(respCache.Data)
+	}
+	return e
+}
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) HandleEvent(c *gin.Context, event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) types() []EventType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	types := make([]EventType, 0, len(r.events))
+	for _, event := range r.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func (r *eventRecorder) last() Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.events[len(r.events)-1]
+}
+
+type failingSetStore struct {
+	persist.CacheStore
+}
+
+func (s failingSetStore) Set(string, interface{}, time.Duration) error {
+	return errors.New("set failed")
+}
+
+func TestEventHandler(t *testing.T) {
+	recorder := &eventRecorder{}
+	hitCount := 0
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+
+	engine := gin.New()
+	engine.GET("/cache/:id",
+		Cache(memoryStore, 3*time.Second,
+			WithCacheStrategyByRequest(func(c *gin.Context) (bool, Strategy) {
+				switch c.Param("id") {
+				case "bypass":
+					return false, Strategy{}
+				case "failing":
+					return true, Strategy{CacheKey: c.Request.RequestURI, CacheStore: failingSetStore{memoryStore}}
+				}
+				return true, Strategy{CacheKey: c.Request.RequestURI}
+			}),
+			WithPrefixKey("prefix:"),
+			WithEventHandler(recorder),
+			WithOnHitCache(func(c *gin.Context) {
+				hitCount++
+			}),
+		),
+		func(c *gin.Context) {
+			if c.Param("id") == "missing" {
+				c.String(http.StatusNotFound, "not found")
+				return
+			}
+			c.String(http.StatusOK, "value")
+		},
+	)
+
+	serve := func(uri string) {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, uri, nil))
+	}
+
+	serve("/cache/1")
+	assert.Equal(t, []EventType{EventMiss, EventStored}, recorder.types())
+	stored := recorder.last()
+	assert.Equal(t, "prefix:/cache/1", stored.Key)
+	assert.Equal(t, http.StatusOK, stored.Status)
+	assert.Equal(t, len("value"), stored.Size)
+	assert.Equal(t, 3*time.Second, stored.Strategy.CacheDuration)
+	assert.Equal(t, memoryStore, stored.Strategy.CacheStore)
+	assert.False(t, stored.Start.IsZero())
+
+	serve("/cache/1")
+	assert.Equal(t, EventHit, recorder.last().Type)
+	assert.Equal(t, 1, hitCount)
+
+	serve("/cache/missing")
+	skipped := recorder.last()
+	assert.Equal(t, EventSkipped, skipped.Type)
+	assert.Equal(t, http.StatusNotFound, skipped.Status)
+	assert.True(t, errors.Is(skipped.Err, ErrStatusNotCacheable))
+
+	serve("/cache/failing")
+	failed := recorder.last()
+	assert.Equal(t, EventStoreFailed, failed.Type)
+	assert.EqualError(t, failed.Err, "set failed")
+
+	serve("/cache/bypass")
+	assert.Equal(t, EventBypass, recorder.last().Type)
+}
+
+func TestEventStaleServed(t *testing.T) {
+	recorder := &eventRecorder{}
+	limiter := NewFillLimiter(1)
+
+	engine := gin.New()
+	engine.GET("/cache",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 100*time.Millisecond,
+			WithServeStale(time.Minute),
+			WithFillLimit(limiter, 0),
+			WithEventHandler(recorder),
+		),
+		func(c *gin.Context) {
+			c.String(http.StatusOK, "value")
+		},
+	)
+
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cache", nil))
+	time.Sleep(150 * time.Millisecond)
+
+	// occupy the only slot, so the stale entry is served
+	require.True(t, limiter.acquire(nil, 0))
+	defer limiter.release()
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache", nil))
+	assert.Equal(t, "value", w.Body.String())
+
+	stale := recorder.last()
+	assert.Equal(t, EventStaleServed, stale.Type)
+	assert.Equal(t, len("value"), stale.Size)
+}
+
+func TestNotifyEvictions(t *testing.T) {
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+
+	evicted := make(chan Event, 1)
+	ok := NotifyEvictions(memoryStore, EventHandlerFunc(func(c *gin.Context, event Event) {
+		assert.Nil(t, c)
+		evicted <- event
+	}))
+	require.True(t, ok)
+
+	require.Nil(t, memoryStore.Set("key", "value", 10*time.Millisecond))
+
+	select {
+	case event := <-evicted:
+		assert.Equal(t, EventEvicted, event.Type)
+		assert.Equal(t, "key", event.Key)
+	case <-time.After(2 * time.Second):
+		t.Fatal("eviction is not notified")
+	}
+
+	assert.False(t, NotifyEvictions(failingSetStore{}, EventHandlerFunc(func(*gin.Context, Event) {})))
+}
@@ -1,12 +1,17 @@
 module github.com/chenyahui/gin-cache
 
-go 1.13
+go 1.15
 
 require (
+	github.com/cespare/xxhash/v2 v2.1.2
 	github.com/gin-gonic/gin v1.7.7
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/jellydator/ttlcache/v2 v2.11.1
+	github.com/prometheus/client_golang v1.11.1
 	github.com/stretchr/testify v1.7.1
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
 	golang.org/x/sync v0.0.0-20220722155255-886fb9371eb4
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
-)
\ No newline at end of file
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// hashedKeySeparator separates the readable prefix and the digest of a hashed key
+const hashedKeySeparator = "#"
+
+// KeyHasher returns the digest of the cache key
+type KeyHasher func(key string) string
+
+// SHA256KeyHasher digest the key with SHA-256, it's the safest choice against collisions
+func SHA256KeyHasher(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// XXHashKeyHasher digest the key with the 64 bits xxhash, it's the fastest
+func XXHashKeyHasher(key string) string {
+	return strconv.FormatUint(xxhash.Sum64String(key), 16)
+}
+
+// FNVKeyHasher digest the key with the 64 bits FNV-1a
+func FNVKeyHasher(key string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// WithHashedKey replace the cache keys with their digests by hasher, to bound the key length and not to leak
+// the query data in the key names. The keys keep a readable prefix, the prefix of WithPrefixKey and the route,
+// e.g. "prefix:/users/:id#9f86d0...". The original key is stored in the entry, the entry of another key with
+// the same digest is treated as a miss.
+func WithHashedKey(hasher KeyHasher) Option {
+	return func(c *Config) {
+		if hasher != nil {
+			c.keyHasher = hasher
+		}
+	}
+}
+
+// hashKey returns the key in the store of originalKey, which contains the prefix of WithPrefixKey
+func hashKey(c *gin.Context, cfg *Config, originalKey string) string {
+	if cfg.keyHasher == nil {
+		return originalKey
+	}
+	return cfg.prefixKey + c.FullPath() + hashedKeySeparator + cfg.keyHasher(originalKey)
+}
+
+// isKeyCollision returns whether the entry is stored by another key with the same digest
+func isKeyCollision(respCache *ResponseCache, originalKey string) bool {
+	return respCache.Key != "" && respCache.Key != originalKey
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashedKey(t *testing.T) {
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+
+	engine := gin.New()
+	engine.GET("/cache",
+		CacheByRequestURI(memoryStore, 3*time.Second, WithPrefixKey("prefix:"), WithHashedKey(SHA256KeyHasher)),
+		func(c *gin.Context) {
+			c.String(http.StatusOK, "value")
+		},
+	)
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cache?token=secret", nil))
+
+	storeKey := "prefix:/cache#" + SHA256KeyHasher("prefix:/cache?token=secret")
+	var respCache *ResponseCache
+	require.NoError(t, memoryStore.Get(storeKey, &respCache))
+	assert.Equal(t, "prefix:/cache?token=secret", respCache.Key)
+	assert.Equal(t, "value", string(respCache.Data))
+}
+
+func TestHashedKeyCollision(t *testing.T) {
+	collidingHasher := func(string) string {
+		return "same"
+	}
+
+	engine := gin.New()
+	engine.GET("/cache",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second, WithHashedKey(collidingHasher)),
+		func(c *gin.Context) {
+			c.String(http.StatusOK, c.Query("id"))
+		},
+	)
+
+	serve := func(uri string) string {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, uri, nil))
+		return w.Body.String()
+	}
+
+	assert.Equal(t, "1", serve("/cache?id=1"))
+	assert.Equal(t, "2", serve("/cache?id=2"))
+	assert.Equal(t, "2", serve("/cache?id=2"))
+}
+
+func TestKeyHashers(t *testing.T) {
+	for _, hasher := range []KeyHasher{SHA256KeyHasher, XXHashKeyHasher, FNVKeyHasher} {
+		assert.Equal(t, hasher("/cache?a=1"), hasher("/cache?a=1"))
+		assert.NotEqual(t, hasher("/cache?a=1"), hasher("/cache?a=2"))
+	}
+	assert.Len(t, SHA256KeyHasher("/cache"), 64)
+}
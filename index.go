@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+)
+
+// keyIndexPruneInterval is how many stored entries trigger a prune of the expired entries of keyIndex
+const keyIndexPruneInterval = 1024
+
+// keyIndex records the cached keys by group in local memory, e.g. by route or by principal
+type keyIndex struct {
+	mu sync.Mutex
+	// groups maps the group to the keys of its entries
+	groups map[string]map[string]keyIndexEntry
+	// keys maps the key to its group
+	keys   map[string]string
+	stored int
+}
+
+type keyIndexEntry struct {
+	// store holds the entry, the strategies of a group may use different stores
+	store    persist.CacheStore
+	storedAt time.Time
+	expireAt time.Time
+}
+
+func newKeyIndex() keyIndex {
+	return keyIndex{
+		groups: map[string]map[string]keyIndexEntry{},
+		keys:   map[string]string{},
+	}
+}
+
+// add records key in group, and returns the number of the entries of group
+func (index *keyIndex) add(group, key string, entry keyIndexEntry) int {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if oldGroup, ok := index.keys[key]; ok && oldGroup != group {
+		index.removeLocked(key)
+	}
+
+	entries, ok := index.groups[group]
+	if !ok {
+		entries = map[string]keyIndexEntry{}
+		index.groups[group] = entries
+	}
+	entries[key] = entry
+	index.keys[key] = group
+
+	index.stored++
+	if index.stored%keyIndexPruneInterval == 0 {
+		index.pruneLocked(time.Now())
+	}
+	return len(index.groups[group])
+}
+
+func (index *keyIndex) remove(key string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.removeLocked(key)
+}
+
+func (index *keyIndex) removeLocked(key string) {
+	group, ok := index.keys[key]
+	if !ok {
+		return
+	}
+
+	delete(index.keys, key)
+	delete(index.groups[group], key)
+	if len(index.groups[group]) == 0 {
+		delete(index.groups, group)
+	}
+}
+
+// groupNames returns the groups having entries, sorted
+func (index *keyIndex) groupNames() []string {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.pruneLocked(time.Now())
+
+	groups := make([]string, 0, len(index.groups))
+	for group := range index.groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// groupKeys returns the unexpired keys of group, sorted
+func (index *keyIndex) groupKeys(group string) []string {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	now := time.Now()
+	keys := make([]string, 0, len(index.groups[group]))
+	for key, entry := range index.groups[group] {
+		if entry.expireAt.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// removeGroup removes the entries of group from the index, they're kept in the stores
+func (index *keyIndex) removeGroup(group string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	for key := range index.groups[group] {
+		delete(index.keys, key)
+	}
+	delete(index.groups, group)
+}
+
+// removeOldest removes and returns the entry of group stored first
+func (index *keyIndex) removeOldest(group string) (string, keyIndexEntry, bool) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	var oldestKey string
+	var oldest keyIndexEntry
+	found := false
+	for key, entry := range index.groups[group] {
+		if !found || entry.storedAt.Before(oldest.storedAt) {
+			oldestKey, oldest, found = key, entry, true
+		}
+	}
+	if found {
+		index.removeLocked(oldestKey)
+	}
+	return oldestKey, oldest, found
+}
+
+// pruneLocked removes the expired entries
+func (index *keyIndex) pruneLocked(now time.Time) {
+	for group, entries := range index.groups {
+		for key, entry := range entries {
+			if !entry.expireAt.After(now) {
+				delete(entries, key)
+				delete(index.keys, key)
+			}
+		}
+		if len(entries) == 0 {
+			delete(index.groups, group)
+		}
+	}
+}
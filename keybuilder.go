@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	keyPartSeparator  = "|"
+	keyValueSeparator = "&"
+)
+
+// keyPartEscaper escapes the separators in the free-form parts, e.g. the path, to keep the keys unambiguous
+var keyPartEscaper = strings.NewReplacer("%", "%25", keyPartSeparator, "%7C")
+
+// KeyBuilder composes the cache key from the parts of the request, in the order they are added.
+// Every part is prefixed with its kind, and the names and values are escaped, so that different requests
+// never build the same key. A missing value is omitted, which differs from an empty value.
+//
+//	NewKeyBuilder().Method().Route().Params().Query("page", "size").Header("Accept-Language")
+//
+// builds keys like "GET|route:/users/:id|params:id=42|query:page=1&size=10|header:Accept-Language=en".
+type KeyBuilder struct {
+	parts []func(c *gin.Context) string
+}
+
+// NewKeyBuilder allocate an empty KeyBuilder
+func NewKeyBuilder() *KeyBuilder {
+	return &KeyBuilder{}
+}
+
+// Method add the request method
+func (b *KeyBuilder) Method() *KeyBuilder {
+	return b.add(func(c *gin.Context) string {
+		return c.Request.Method
+	})
+}
+
+// Route add the route pattern, e.g. /users/:id, or the path if the request matches no route
+func (b *KeyBuilder) Route() *KeyBuilder {
+	return b.add(func(c *gin.Context) string {
+		route := c.FullPath()
+		if route == "" {
+			// a literal path may look like a route pattern, e.g. /users/:id
+			return "path:" + keyPartEscaper.Replace(c.Request.URL.Path)
+		}
+		return "route:" + keyPartEscaper.Replace(route)
+	})
+}
+
+// Path add the request path
+func (b *KeyBuilder) Path() *KeyBuilder {
+	return b.add(func(c *gin.Context) string {
+		return "path:" + keyPartEscaper.Replace(c.Request.URL.Path)
+	})
+}
+
+// Params add the path params of names, or all the path params without names
+func (b *KeyBuilder) Params(names ...string) *KeyBuilder {
+	return b.add(func(c *gin.Context) string {
+		values := url.Values{}
+		for _, param := range c.Params {
+			if len(names) == 0 || containsString(names, param.Key) {
+				values.Add(param.Key, param.Value)
+			}
+		}
+		return "params:" + encodeKeyValues(values)
+	})
+}
+
+// Query add the query params of names, or all the query params without names
+func (b *KeyBuilder) Query(names ...string) *KeyBuilder {
+	return b.add(func(c *gin.Context) string {
+		query := c.Request.URL.Query()
+		if len(names) == 0 {
+			return "query:" + encodeKeyValues(query)
+		}
+
+		values := url.Values{}
+		for _, name := range names {
+			if v, ok := query[name]; ok {
+				values[name] = v
+			}
+		}
+		return "query:" + encodeKeyValues(values)
+	})
+}
+
+// QueryExcept add all the query params except names, e.g. the tracking params
+func (b *KeyBuilder) QueryExcept(names ...string) *KeyBuilder {
+	return b.add(func(c *gin.Context) string {
+		values := c.Request.URL.Query()
+		for _, name := range names {
+			values.Del(name)
+		}
+		return "query:" + encodeKeyValues(values)
+	})
+}
+
+// NormalizedQuery add the query params normalized by normalization
+func (b *KeyBuilder) NormalizedQuery(normalization QueryNormalization) *KeyBuilder {
+	return b.add(func(c *gin.Context) string {
+		return "query:" + encodeKeyValues(normalization.normalize(c.Request.URL.Query()))
+	})
+}
+
+// Header add the request headers of names
+func (b *KeyBuilder) Header(names ...string) *KeyBuilder {
+	canonicalNames := make([]string, 0, len(names))
+	for _, name := range names {
+		canonicalNames = append(canonicalNames, http.CanonicalHeaderKey(name))
+	}
+
+	return b.add(func(c *gin.Context) string {
+		values := url.Values{}
+		for _, name := range canonicalNames {
+			if v, ok := c.Request.Header[name]; ok {
+				values[name] = v
+			}
+		}
+		return "header:" + encodeKeyValues(values)
+	})
+}
+
+// Cookie add the request cookies of names
+func (b *KeyBuilder) Cookie(names ...string) *KeyBuilder {
+	return b.add(func(c *gin.Context) string {
+		values := url.Values{}
+		for _, name := range names {
+			if cookie, err := c.Request.Cookie(name); err == nil {
+				values.Set(name, cookie.Value)
+			}
+		}
+		return "cookie:" + encodeKeyValues(values)
+	})
+}
+
+// ContextValue add the values set by c.Set of keys, e.g. the tenant set by a middleware before the cache.
+// The values are formatted with fmt.Sprint.
+func (b *KeyBuilder) ContextValue(keys ...string) *KeyBuilder {
+	return b.add(func(c *gin.Context) string {
+		values := url.Values{}
+		for _, key := range keys {
+			if v, ok := c.Get(key); ok {
+				values.Set(key, fmt.Sprint(v))
+			}
+		}
+		return "ctx:" + encodeKeyValues(values)
+	})
+}
+
+// Build returns the cache key of c
+func (b *KeyBuilder) Build(c *gin.Context) string {
+	parts := make([]string, 0, len(b.parts))
+	for _, part := range b.parts {
+		parts = append(parts, part(c))
+	}
+	return strings.Join(parts, keyPartSeparator)
+}
+
+// Strategy returns a GetCacheStrategyByRequest caching every request with the key built by b
+func (b *KeyBuilder) Strategy() GetCacheStrategyByRequest {
+	return func(c *gin.Context) (bool, Strategy) {
+		return true, Strategy{
+			CacheKey: b.Build(c),
+		}
+	}
+}
+
+func (b *KeyBuilder) add(part func(c *gin.Context) string) *KeyBuilder {
+	b.parts = append(b.parts, part)
+	return b
+}
+
+// encodeKeyValues encodes values sorted by key, and the values of a key are sorted as well
+func encodeKeyValues(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(values))
+	for _, key := range keys {
+		vals := append([]string(nil), values[key]...)
+		sort.Strings(vals)
+		for _, val := range vals {
+			pairs = append(pairs, url.QueryEscape(key)+"="+url.QueryEscape(val))
+		}
+	}
+	return strings.Join(pairs, keyValueSeparator)
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
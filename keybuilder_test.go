@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildKey(builder *KeyBuilder, route string, req *http.Request) string {
+	var key string
+
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set("tenant", "acme")
+	})
+	engine.Handle(req.Method, route, func(c *gin.Context) {
+		key = builder.Build(c)
+	})
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+
+	return key
+}
+
+func TestKeyBuilder(t *testing.T) {
+	builder := NewKeyBuilder().
+		Method().
+		Route().
+		Params().
+		Query("page", "size").
+		Header("accept-language").
+		Cookie("currency").
+		ContextValue("tenant")
+
+	req := httptest.NewRequest(http.MethodGet, "/users/42?size=10&utm_source=x&page=1", nil)
+	req.Header.Set("Accept-Language", "en")
+	req.AddCookie(&http.Cookie{Name: "currency", Value: "EUR"})
+
+	assert.Equal(t,
+		"GET|route:/users/:id|params:id=42|query:page=1&size=10|header:Accept-Language=en|cookie:currency=EUR|ctx:tenant=acme",
+		buildKey(builder, "/users/:id", req),
+	)
+
+	// the query order and the unlisted params don't matter
+	other := httptest.NewRequest(http.MethodGet, "/users/42?page=1&size=10", nil)
+	other.Header.Set("Accept-Language", "en")
+	other.AddCookie(&http.Cookie{Name: "currency", Value: "EUR"})
+	assert.Equal(t, buildKey(builder, "/users/:id", req), buildKey(builder, "/users/:id", other))
+}
+
+func TestKeyBuilderEscape(t *testing.T) {
+	builder := NewKeyBuilder().Path().QueryExcept("utm_source")
+
+	assert.Equal(t,
+		"path:/a%7Cb|query:a=1%26b%3D2&c=",
+		buildKey(builder, "/*path", httptest.NewRequest(http.MethodGet, "/a%7Cb?a=1%26b%3D2&c=&utm_source=x", nil)),
+	)
+
+	// an empty value differs from a missing one
+	assert.NotEqual(t,
+		buildKey(builder, "/*path", httptest.NewRequest(http.MethodGet, "/a?c=", nil)),
+		buildKey(builder, "/*path", httptest.NewRequest(http.MethodGet, "/a", nil)),
+	)
+}
+
+func TestKeyBuilderRouteFallback(t *testing.T) {
+	builder := NewKeyBuilder().Route()
+
+	var key string
+	engine := gin.New()
+	engine.NoRoute(func(c *gin.Context) {
+		key = builder.Build(c)
+	})
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/:id", nil))
+
+	// the unmatched path never builds the key of the route pattern
+	assert.Equal(t, "path:/users/:id", key)
+	assert.NotEqual(t, buildKey(builder, "/users/:id", httptest.NewRequest(http.MethodGet, "/users/42", nil)), key)
+}
+
+func TestKeyBuilderStrategy(t *testing.T) {
+	builder := NewKeyBuilder().Route().Query("page")
+
+	calls := 0
+	engine := gin.New()
+	engine.GET("/items",
+		Cache(persist.NewMemoryStore(1*time.Minute), 3*time.Second, WithCacheStrategyByRequest(builder.Strategy())),
+		func(c *gin.Context) {
+			calls++
+			c.String(http.StatusOK, "items")
+		},
+	)
+
+	for _, uri := range []string{"/items?page=1", "/items?page=1&_=1690000", "/items?page=2"} {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, uri, nil))
+	}
+	assert.Equal(t, 2, calls)
+}
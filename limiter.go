@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrFillLimitExceeded no backend call slot is available within the wait timeout
+var ErrFillLimitExceeded = errors.New("gin-cache: fill limit exceeded")
+
+// FillLimiter bounds the concurrent backend calls on cache miss.
+// Share one FillLimiter between middlewares to limit them together, e.g. all the routes of a store.
+type FillLimiter struct {
+	slots chan struct{}
+
+	waiting  int64
+	rejected int64
+}
+
+// FillLimiterStats is a snapshot of the FillLimiter
+type FillLimiterStats struct {
+	// Limit is the max concurrent backend calls
+	Limit int
+	// InFlight is the current backend calls
+	InFlight int
+	// Waiting is the queue depth, the requests waiting for a slot
+	Waiting int64
+	// Rejected is the total requests failed to get a slot in time
+	Rejected int64
+}
+
+// NewFillLimiter allocate a FillLimiter allowing limit concurrent backend calls
+func NewFillLimiter(limit int) *FillLimiter {
+	if limit <= 0 {
+		limit = 1
+	}
+
+	return &FillLimiter{
+		slots: make(chan struct{}, limit),
+	}
+}
+
+// Stats returns the current stats of the limiter
+func (l *FillLimiter) Stats() FillLimiterStats {
+	return FillLimiterStats{
+		Limit:    cap(l.slots),
+		InFlight: len(l.slots),
+		Waiting:  atomic.LoadInt64(&l.waiting),
+		Rejected: atomic.LoadInt64(&l.rejected),
+	}
+}
+
+// acquire waits for a slot until timeout or the request is canceled
+func (l *FillLimiter) acquire(c *gin.Context, timeout time.Duration) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if timeout <= 0 {
+		atomic.AddInt64(&l.rejected, 1)
+		return false
+	}
+
+	atomic.AddInt64(&l.waiting, 1)
+	defer atomic.AddInt64(&l.waiting, -1)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-c.Request.Context().Done():
+	}
+
+	atomic.AddInt64(&l.rejected, 1)
+	return false
+}
+
+func (l *FillLimiter) release() {
+	<-l.slots
+}
+
+// OnFillLimitExceededCallback define the callback when no backend call slot is available and no stale entry exists
+type OnFillLimitExceededCallback func(c *gin.Context)
+
+var defaultFillLimitExceededCallback = func(c *gin.Context) {
+	c.AbortWithStatus(http.StatusServiceUnavailable)
+}
+
+// acquireFillLimiter returns a release function, or false if the fill limit is exceeded
+func acquireFillLimiter(c *gin.Context, cfg *Config, limiter *FillLimiter) (func(), bool) {
+	if limiter == nil {
+		return func() {}, true
+	}
+
+	if !limiter.acquire(c, cfg.fillLimitWaitTimeout) {
+		return nil, false
+	}
+	return limiter.release, true
+}
+
+// replyFillLimitExceeded reply with the stale entry if any, otherwise the callback decides the response
+func replyFillLimitExceeded(c *gin.Context, cfg *Config, baseEvent Event, staleCache *ResponseCache) {
+	if staleCache != nil {
+		replyWithCache(c, cfg, staleCache)
+		emitEvent(c, cfg, baseEvent.withResponse(EventStaleServed, staleCache))
+		return
+	}
+	cfg.fillLimitExceededCallback(c)
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFillLimitEngine(cacheDuration time.Duration, opts ...Option) *gin.Engine {
+	var callCount int32
+
+	engine := gin.New()
+	engine.GET("/cache",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), cacheDuration, opts...),
+		func(c *gin.Context) {
+			if c.Query("slow") != "" {
+				time.Sleep(200 * time.Millisecond)
+			}
+			c.String(http.StatusOK, fmt.Sprintf("call:%d", atomic.AddInt32(&callCount, 1)))
+		},
+	)
+	return engine
+}
+
+func serveConcurrently(engine *gin.Engine, slowURL string, url string) *httptest.ResponseRecorder {
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, slowURL, nil))
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	wg.Wait()
+	return w
+}
+
+func TestFillLimitExceeded(t *testing.T) {
+	limiter := NewFillLimiter(1)
+	engine := newFillLimitEngine(3*time.Second, WithFillLimit(limiter, 50*time.Millisecond))
+
+	w := serveConcurrently(engine, "/cache?slow=1", "/cache?uid=1")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	stats := limiter.Stats()
+	assert.Equal(t, 1, stats.Limit)
+	assert.Equal(t, 0, stats.InFlight)
+	assert.Equal(t, int64(0), stats.Waiting)
+	assert.Equal(t, int64(1), stats.Rejected)
+}
+
+func TestFillLimitWait(t *testing.T) {
+	limiter := NewFillLimiter(1)
+	engine := newFillLimitEngine(3*time.Second,
+		WithFillLimit(limiter, 1*time.Second),
+		WithOnFillLimitExceeded(func(c *gin.Context) {
+			c.AbortWithStatus(http.StatusTooManyRequests)
+		}),
+	)
+
+	w := serveConcurrently(engine, "/cache?slow=1", "/cache?uid=1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(0), limiter.Stats().Rejected)
+}
+
+func TestFillLimitServeStale(t *testing.T) {
+	limiter := NewFillLimiter(1)
+	engine := newFillLimitEngine(100*time.Millisecond,
+		WithFillLimit(limiter, 50*time.Millisecond),
+		WithServeStale(1*time.Minute),
+	)
+
+	w1 := httptest.NewRecorder()
+	engine.ServeHTTP(w1, httptest.NewRequest(http.MethodGet, "/cache?uid=1", nil))
+	assert.Equal(t, "call:1", w1.Body.String())
+
+	time.Sleep(150 * time.Millisecond)
+
+	// the entry has expired, but it's served because the backend is busy
+	w2 := serveConcurrently(engine, "/cache?slow=1", "/cache?uid=1")
+	assert.Equal(t, http.StatusOK, w2.Code)
+	assert.Equal(t, "call:1", w2.Body.String())
+
+	// the backend is idle, the expired entry is refreshed
+	w3 := httptest.NewRecorder()
+	engine.ServeHTTP(w3, httptest.NewRequest(http.MethodGet, "/cache?uid=1", nil))
+	assert.Equal(t, "call:3", w3.Body.String())
+}
+
+func TestFillLimiterStrategy(t *testing.T) {
+	limiter := NewFillLimiter(1)
+	engine := newFillLimitEngine(3*time.Second,
+		WithFillLimit(nil, 50*time.Millisecond),
+		WithCacheStrategyByRequest(func(c *gin.Context) (bool, Strategy) {
+			return true, Strategy{
+				CacheKey:    c.Request.RequestURI,
+				FillLimiter: limiter,
+			}
+		}),
+	)
+
+	w := serveConcurrently(engine, "/cache?slow=1", "/cache?uid=1")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, int64(1), limiter.Stats().Rejected)
+}
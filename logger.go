@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Level is the severity of a log
+type Level int
+
+const (
+	// LevelDebug logs the decisions of the middleware, e.g. why a response is not cached
+	LevelDebug Level = iota
+	// LevelInfo logs the notable events
+	LevelInfo
+	// LevelWarn logs the recoverable problems
+	LevelWarn
+	// LevelError logs the failures, e.g. the cache store errors
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// StructuredLogger define the leveled logger interface with key/value fields.
+// keysAndValues are alternating keys and values, keys are strings, e.g. "key", cacheKey, "route", c.FullPath().
+type StructuredLogger interface {
+	// Enabled returns whether the logs at level are output, the middleware skips building them otherwise
+	Enabled(level Level) bool
+
+	Log(level Level, msg string, keysAndValues ...interface{})
+}
+
+// WithStructuredLogger set the custom structured logger, it replaces the logger of WithLogger
+func WithStructuredLogger(l StructuredLogger) Option {
+	return func(c *Config) {
+		if l != nil {
+			c.logger = l
+		}
+	}
+}
+
+// errorfLogger adapts Logger to StructuredLogger, only the error logs are output
+type errorfLogger struct {
+	logger Logger
+}
+
+func (l errorfLogger) Enabled(level Level) bool {
+	return level >= LevelError
+}
+
+func (l errorfLogger) Log(level Level, msg string, keysAndValues ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.logger.Errorf("%s", formatLog(msg, keysAndValues))
+}
+
+// formatLog formats msg with the fields as key=value pairs
+func formatLog(msg string, keysAndValues []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+
+	for i := 0; i < len(keysAndValues); i += 2 {
+		b.WriteByte(' ')
+		if i+1 < len(keysAndValues) {
+			fmt.Fprintf(&b, "%v=%v", keysAndValues[i], keysAndValues[i+1])
+		} else {
+			fmt.Fprintf(&b, "%v", keysAndValues[i])
+		}
+	}
+	return b.String()
+}
+
+// ZapSugaredLogger is the subset of *zap.SugaredLogger used by the adapter
+type ZapSugaredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// NewZapLogger adapts a zap-style sugared logger, e.g. zap.S(), to StructuredLogger
+func NewZapLogger(l ZapSugaredLogger, minLevel Level) StructuredLogger {
+	return zapLogger{logger: l, minLevel: minLevel}
+}
+
+type zapLogger struct {
+	logger   ZapSugaredLogger
+	minLevel Level
+}
+
+func (l zapLogger) Enabled(level Level) bool {
+	return level >= l.minLevel
+}
+
+func (l zapLogger) Log(level Level, msg string, keysAndValues ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	switch level {
+	case LevelDebug:
+		l.logger.Debugw(msg, keysAndValues...)
+	case LevelInfo:
+		l.logger.Infow(msg, keysAndValues...)
+	case LevelWarn:
+		l.logger.Warnw(msg, keysAndValues...)
+	default:
+		l.logger.Errorw(msg, keysAndValues...)
+	}
+}
+
+// logf output the log with the route of c, if the level is enabled
+func logf(c *gin.Context, cfg *Config, level Level, msg string, keysAndValues ...interface{}) {
+	if !cfg.logger.Enabled(level) {
+		return
+	}
+
+	cfg.logger.Log(level, msg, append(keysAndValues, "route", c.FullPath())...)
+}
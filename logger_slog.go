@@ -0,0 +1,39 @@
+//go:build go1.21
+// +build go1.21
+
+package cache
+
+import (
+	"context"
+	"log/slog"
+)
+
+// NewSlogLogger adapts *slog.Logger to StructuredLogger
+func NewSlogLogger(l *slog.Logger) StructuredLogger {
+	return slogLogger{logger: l}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogLogger) Enabled(level Level) bool {
+	return l.logger.Enabled(context.Background(), slogLevel(level))
+}
+
+func (l slogLogger) Log(level Level, msg string, keysAndValues ...interface{}) {
+	l.logger.Log(context.Background(), slogLevel(level), msg, keysAndValues...)
+}
+
+func slogLevel(level Level) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelInfo:
+		return slog.LevelInfo
+	case LevelWarn:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type logEntry struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+type fakeZapLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *fakeZapLogger) record(level, msg string, keysAndValues []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fields := make(map[string]interface{})
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fields[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+	}
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, fields: fields})
+}
+
+func (l *fakeZapLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	l.record("debug", msg, keysAndValues)
+}
+
+func (l *fakeZapLogger) Infow(msg string, keysAndValues ...interface{}) {
+	l.record("info", msg, keysAndValues)
+}
+
+func (l *fakeZapLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	l.record("warn", msg, keysAndValues)
+}
+
+func (l *fakeZapLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.record("error", msg, keysAndValues)
+}
+
+func (l *fakeZapLogger) find(msg string) (logEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, entry := range l.entries {
+		if entry.msg == msg {
+			return entry, true
+		}
+	}
+	return logEntry{}, false
+}
+
+type errorfRecorder struct {
+	logs []string
+}
+
+func (l *errorfRecorder) Errorf(format string, args ...interface{}) {
+	l.logs = append(l.logs, fmt.Sprintf(format, args...))
+}
+
+func TestErrorfLoggerAdapter(t *testing.T) {
+	recorder := &errorfRecorder{}
+	cfg := newConfigByOpts(WithLogger(recorder))
+
+	assert.False(t, cfg.logger.Enabled(LevelWarn))
+	assert.True(t, cfg.logger.Enabled(LevelError))
+
+	cfg.logger.Log(LevelDebug, "ignored", "key", "k")
+	cfg.logger.Log(LevelError, "set cache error", "error", "boom", "key", "/cache")
+
+	require.Len(t, recorder.logs, 1)
+	assert.Equal(t, "set cache error error=boom key=/cache", recorder.logs[0])
+}
+
+func TestStructuredLoggerDebugEvents(t *testing.T) {
+	logger := &fakeZapLogger{}
+
+	engine := gin.New()
+	engine.GET("/cache/:id",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second,
+			WithStructuredLogger(NewZapLogger(logger, LevelDebug))),
+		func(c *gin.Context) {
+			if c.Param("id") == "missing" {
+				c.String(http.StatusNotFound, "not found")
+				return
+			}
+			c.String(http.StatusOK, "value")
+		},
+	)
+
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cache/1", nil))
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cache/missing", nil))
+
+	stored, ok := logger.find("response stored")
+	require.True(t, ok)
+	assert.Equal(t, "debug", stored.level)
+	assert.Equal(t, "/cache/1", stored.fields["key"])
+	assert.Equal(t, http.StatusOK, stored.fields["status"])
+	assert.Equal(t, len("value"), stored.fields["size"])
+	assert.Equal(t, "/cache/:id", stored.fields["route"])
+
+	skipped, ok := logger.find("response not stored")
+	require.True(t, ok)
+	assert.Equal(t, "/cache/missing", skipped.fields["key"])
+	assert.Equal(t, http.StatusNotFound, skipped.fields["status"])
+	assert.Equal(t, ErrStatusNotCacheable, skipped.fields["reason"])
+
+	_, ok = logger.find("cache strategy applied")
+	assert.True(t, ok)
+
+	// the debug events are skipped above the min level
+	quiet := &fakeZapLogger{}
+	zap := NewZapLogger(quiet, LevelWarn)
+	zap.Log(LevelDebug, "debug")
+	zap.Log(LevelWarn, "warn")
+	require.Len(t, quiet.entries, 1)
+	assert.Equal(t, "warn", quiet.entries[0].level)
+}
@@ -0,0 +1,136 @@
+// Package metrics exports the metrics of gin-cache to prometheus
+package metrics
+
+import (
+	cache "github.com/chenyahui/gin-cache"
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	labelRoute     = "route"
+	labelStore     = "store"
+	labelOperation = "operation"
+)
+
+// PrometheusCollector collects the metrics of the cache middlewares and the cache stores.
+// Register it on a prometheus.Registerer, then use Options for the middlewares and WrapStore for the stores.
+type PrometheusCollector struct {
+	hits       *prometheus.CounterVec
+	misses     *prometheus.CounterVec
+	shares     *prometheus.CounterVec
+	bypasses   *prometheus.CounterVec
+	entrySizes *prometheus.HistogramVec
+
+	storeErrors    *prometheus.CounterVec
+	storeDurations *prometheus.HistogramVec
+}
+
+// NewPrometheusCollector allocate a collector, all the metric names are prefixed with namespace
+func NewPrometheusCollector(namespace string) *PrometheusCollector {
+	routeLabels := []string{labelRoute, labelStore}
+	storeLabels := []string{labelStore, labelOperation}
+
+	return &PrometheusCollector{
+		hits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_hits_total",
+			Help:      "Total number of requests replied from the cache.",
+		}, routeLabels),
+		misses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_misses_total",
+			Help:      "Total number of requests not found in the cache.",
+		}, routeLabels),
+		shares: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_singleflight_shares_total",
+			Help:      "Total number of requests replied with the response of another request in the singleflight.",
+		}, routeLabels),
+		bypasses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_bypasses_total",
+			Help:      "Total number of requests not cached by the strategy.",
+		}, routeLabels),
+		entrySizes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "cache_entry_size_bytes",
+			Help:      "Size of the response bodies written to the cache store.",
+			Buckets:   prometheus.ExponentialBuckets(256, 4, 8),
+		}, routeLabels),
+		storeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_store_errors_total",
+			Help:      "Total number of failed cache store operations, cache misses excluded.",
+		}, storeLabels),
+		storeDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "cache_store_operation_duration_seconds",
+			Help:      "Latency of the cache store operations.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}, storeLabels),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (p *PrometheusCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range p.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (p *PrometheusCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range p.collectors() {
+		collector.Collect(ch)
+	}
+}
+
+// Register registers the collector on registerer
+func (p *PrometheusCollector) Register(registerer prometheus.Registerer) error {
+	return registerer.Register(p)
+}
+
+func (p *PrometheusCollector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		p.hits, p.misses, p.shares, p.bypasses, p.entrySizes, p.storeErrors, p.storeDurations,
+	}
+}
+
+// Options returns the options of a cache middleware, which count the requests labeled by c.FullPath() and storeName.
+// They observe the events of the middleware, so the callbacks set by WithOnHitCache, WithOnMissCache,
+// WithOnShareSingleFlight and WithOnBypassCache are kept.
+func (p *PrometheusCollector) Options(storeName string) []cache.Option {
+	return []cache.Option{
+		cache.WithEventHandler(cache.EventHandlerFunc(func(c *gin.Context, event cache.Event) {
+			p.handleEvent(c.FullPath(), storeName, event)
+		})),
+	}
+}
+
+func (p *PrometheusCollector) handleEvent(route, storeName string, event cache.Event) {
+	switch event.Type {
+	case cache.EventHit:
+		p.hits.WithLabelValues(route, storeName).Inc()
+	case cache.EventMiss:
+		p.misses.WithLabelValues(route, storeName).Inc()
+	case cache.EventShared:
+		p.shares.WithLabelValues(route, storeName).Inc()
+	case cache.EventBypass:
+		p.bypasses.WithLabelValues(route, storeName).Inc()
+	case cache.EventStored:
+		p.entrySizes.WithLabelValues(route, storeName).Observe(float64(event.Size))
+	}
+}
+
+// WrapStore returns a persist.InstrumentedStore observing the latency and errors of store labeled by storeName
+func (p *PrometheusCollector) WrapStore(storeName string, store persist.CacheStore) persist.CacheStore {
+	return persist.NewInstrumentedStore(store, persist.WithObservationHook(func(observation persist.Observation) {
+		operation := string(observation.Operation)
+		p.storeDurations.WithLabelValues(storeName, operation).Observe(observation.Latency.Seconds())
+		if observation.Err != nil && !observation.Miss {
+			p.storeErrors.WithLabelValues(storeName, operation).Inc()
+		}
+	}))
+}
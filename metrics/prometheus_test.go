@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cache "github.com/chenyahui/gin-cache"
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// failingStore fails every Set
+type failingStore struct {
+	persist.CacheStore
+}
+
+func (s *failingStore) Set(string, interface{}, time.Duration) error {
+	return errors.New("set failed")
+}
+
+func TestPrometheusCollector(t *testing.T) {
+	collector := NewPrometheusCollector("test")
+	registry := prometheus.NewRegistry()
+	require.NoError(t, collector.Register(registry))
+
+	memoryStore := collector.WrapStore("memory", persist.NewMemoryStore(1*time.Minute))
+	failing := collector.WrapStore("failing", &failingStore{CacheStore: persist.NewMemoryStore(1 * time.Minute)})
+
+	// the callbacks set before are kept
+	callbackHits := 0
+	engine := gin.New()
+	engine.GET("/users/:id",
+		cache.CacheByRequestURI(memoryStore, 1*time.Minute, append(
+			[]cache.Option{cache.WithOnHitCache(func(c *gin.Context) { callbackHits++ })},
+			append(collector.Options("memory"), cache.WithCacheStrategyByRequest(func(c *gin.Context) (bool, cache.Strategy) {
+				return c.Query("nocache") == "", cache.Strategy{CacheKey: c.Request.RequestURI}
+			}))...,
+		)...),
+		func(c *gin.Context) {
+			c.String(http.StatusOK, "user:"+c.Param("id"))
+		},
+	)
+	engine.GET("/failing",
+		cache.CacheByRequestURI(failing, 1*time.Minute, collector.Options("failing")...),
+		func(c *gin.Context) {
+			c.String(http.StatusOK, "failing")
+		},
+	)
+
+	for _, url := range []string{"/users/1", "/users/1", "/users/1", "/users/2", "/users/1?nocache=1", "/failing"} {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+	}
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(collector.hits.WithLabelValues("/users/:id", "memory")))
+	assert.Equal(t, 2, callbackHits)
+	assert.Equal(t, float64(2), testutil.ToFloat64(collector.misses.WithLabelValues("/users/:id", "memory")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector.bypasses.WithLabelValues("/users/:id", "memory")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector.misses.WithLabelValues("/failing", "failing")))
+
+	assert.Equal(t, float64(0), testutil.ToFloat64(collector.storeErrors.WithLabelValues("memory", string(persist.OperationGet))))
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector.storeErrors.WithLabelValues("failing", string(persist.OperationSet))))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	histograms := map[string]uint64{}
+	entrySizeLabels := map[string]string{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			if metric.GetHistogram() != nil {
+				histograms[family.GetName()] += metric.GetHistogram().GetSampleCount()
+			}
+			if family.GetName() == "test_cache_entry_size_bytes" {
+				for _, label := range metric.GetLabel() {
+					entrySizeLabels[label.GetName()] = label.GetValue()
+				}
+			}
+		}
+	}
+	assert.Equal(t, map[string]string{labelRoute: "/users/:id", labelStore: "memory"}, entrySizeLabels)
+	// 2 sets of /users, the failed set of /failing isn't observed
+	assert.Equal(t, uint64(2), histograms["test_cache_entry_size_bytes"])
+	// 5 gets, 3 sets
+	assert.Equal(t, uint64(8), histograms["test_cache_store_operation_duration_seconds"])
+}
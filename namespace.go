@@ -0,0 +1,248 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	namespaceVersionKeyPrefix = "gin-cache:namespace:"
+
+	defaultNamespaceRefreshInterval = 1 * time.Second
+
+	// namespaceInitialVersion is the version of the namespaces with a version TTL never bumped,
+	// or whose version has expired
+	namespaceInitialVersion = "0"
+)
+
+// NamespaceOption represents the optional function of Namespace
+type NamespaceOption func(ns *Namespace)
+
+// WithNamespaceRefreshInterval set how long the version is reused before reading the store again, default 1s.
+// A bump on another replica takes effect here after at most interval.
+func WithNamespaceRefreshInterval(interval time.Duration) NamespaceOption {
+	return func(ns *Namespace) {
+		if interval > 0 {
+			ns.refreshInterval = interval
+		}
+	}
+}
+
+// WithNamespaceVersionTTL stores the bumped version for ttl instead of without expiration, and reads a missing
+// version as the initial one without writing it, so that the unused namespaces leave nothing in the store.
+// Once the version expires, the namespace reverts to the initial version, so ttl must exceed the longest lifetime
+// of its entries, including the stale period, plus the refresh interval, otherwise the entries stored before
+// the first bump become reachable again.
+func WithNamespaceVersionTTL(ttl time.Duration) NamespaceOption {
+	return func(ns *Namespace) {
+		if ttl > 0 {
+			ns.versionTTL = ttl
+		}
+	}
+}
+
+// Namespace is a generation version stored in the cache store, and folded into the keys of the middlewares
+// using WithNamespace. Bump changes the version, so that the old entries become unreachable and expire naturally.
+type Namespace struct {
+	name            string
+	store           persist.CacheStore
+	refreshInterval time.Duration
+	versionTTL      time.Duration
+
+	// fetchMu serializes the reads of the store, mu is never held during them
+	fetchMu sync.Mutex
+
+	mu         sync.Mutex
+	version    string
+	fetchedAt  time.Time
+	refreshing bool
+	// bumps counts the local bumps, so that a read started before a bump doesn't revert it
+	bumps int64
+}
+
+// NewNamespace allocate the namespace name, whose version is stored in store, usually the cache store itself.
+// The version is stored without expiration, if the store evicts it anyway, a new version is generated,
+// which invalidates the namespace as a bump. See WithNamespaceVersionTTL for the namespaces created on demand.
+func NewNamespace(name string, store persist.CacheStore, opts ...NamespaceOption) *Namespace {
+	ns := &Namespace{
+		name:            name,
+		store:           store,
+		refreshInterval: defaultNamespaceRefreshInterval,
+	}
+
+	for _, opt := range opts {
+		opt(ns)
+	}
+
+	return ns
+}
+
+// WithNamespace fold the versions of namespaces into the keys, e.g. a global namespace and one per API.
+// Bumping any of them invalidates the entries of the middleware.
+// If a version can't be read from the store, the request is not cached.
+func WithNamespace(namespaces ...*Namespace) Option {
+	return func(c *Config) {
+		for _, ns := range namespaces {
+			if ns != nil {
+				c.namespaces = append(c.namespaces, ns)
+			}
+		}
+	}
+}
+
+// Name returns the name of the namespace
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Version returns the current version, it's read from the store at most once per refresh interval.
+// While the version is read, the other callers keep using the known one rather than waiting.
+func (ns *Namespace) Version() (string, error) {
+	ns.mu.Lock()
+	if ns.version != "" && (ns.refreshing || time.Since(ns.fetchedAt) < ns.refreshInterval) {
+		version := ns.version
+		ns.mu.Unlock()
+		return version, nil
+	}
+	ns.refreshing = true
+	ns.mu.Unlock()
+
+	return ns.refresh()
+}
+
+// refresh reads the version from the store, initializing it if missing
+func (ns *Namespace) refresh() (string, error) {
+	ns.fetchMu.Lock()
+	defer ns.fetchMu.Unlock()
+
+	ns.mu.Lock()
+	if ns.version != "" && time.Since(ns.fetchedAt) < ns.refreshInterval {
+		// refreshed or bumped by another caller meanwhile
+		ns.refreshing = false
+		version := ns.version
+		ns.mu.Unlock()
+		return version, nil
+	}
+	bumps := ns.bumps
+	ns.mu.Unlock()
+
+	var version string
+	err := ns.store.Get(ns.versionKey(), &version)
+	if errors.Is(err, persist.ErrCacheMiss) {
+		if ns.versionTTL > 0 {
+			version, err = namespaceInitialVersion, nil
+		} else {
+			version, err = ns.initVersion()
+		}
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.refreshing = false
+	if err != nil {
+		if ns.version != "" {
+			// keep using the known version while the store is unavailable
+			return ns.version, nil
+		}
+		return "", err
+	}
+
+	if ns.bumps != bumps {
+		// bumped meanwhile, the version read may be the previous one
+		return ns.version, nil
+	}
+
+	ns.version = version
+	ns.fetchedAt = time.Now()
+	return version, nil
+}
+
+// Bump changes the version, the entries of the namespace become unreachable immediately on this replica
+// and after the refresh interval on the others.
+func (ns *Namespace) Bump() (string, error) {
+	ns.mu.Lock()
+	version := newNamespaceVersion(ns.version)
+	ns.mu.Unlock()
+
+	expire := persist.NoExpiration
+	if ns.versionTTL > 0 {
+		expire = ns.versionTTL
+	}
+	if err := ns.store.Set(ns.versionKey(), version, expire); err != nil {
+		return "", err
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.bumps++
+	ns.version = version
+	ns.fetchedAt = time.Now()
+	return version, nil
+}
+
+// initVersion stores a new version, then reads it back in case another replica has initialized it concurrently
+func (ns *Namespace) initVersion() (string, error) {
+	if err := ns.store.Set(ns.versionKey(), newNamespaceVersion(""), persist.NoExpiration); err != nil {
+		return "", err
+	}
+
+	var version string
+	if err := ns.store.Get(ns.versionKey(), &version); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// newNamespaceVersion returns a version different from previous, unlike a counter it survives the eviction
+// of the version
+func newNamespaceVersion(previous string) string {
+	version := strconv.FormatInt(time.Now().UnixNano(), 36)
+	if version == previous {
+		version += "1"
+	}
+	return version
+}
+
+func (ns *Namespace) versionKey() string {
+	return namespaceVersionKeyPrefix + ns.name
+}
+
+// namespacePrefix returns the key prefix of the versions of cfg.namespaces, of the route of c, see WithRouteIndex,
+// and of the principal of c, see WithPrivateCache
+func namespacePrefix(c *gin.Context, cfg *Config) (string, error) {
+	// cfg.namespaces is shared by the requests, never append to it in place
+	namespaces := cfg.namespaces[:len(cfg.namespaces):len(cfg.namespaces)]
+	if cfg.routeIndex != nil {
+		if route := c.FullPath(); route != "" {
+			namespaces = append(namespaces, cfg.routeIndex.namespace(route))
+		}
+	}
+	if cfg.privateCache != nil {
+		if principal, ok := cfg.privateCache.principal(c); ok {
+			namespaces = append(namespaces, cfg.privateCache.namespace(principal))
+		}
+	}
+
+	var b strings.Builder
+	for _, ns := range namespaces {
+		version, err := ns.Version()
+		if err != nil {
+			return "", err
+		}
+
+		b.WriteString("ns:")
+		b.WriteString(keyPartEscaper.Replace(ns.name))
+		b.WriteString("@")
+		b.WriteString(version)
+		b.WriteString(keyPartSeparator)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type unavailableStore struct {
+	persist.CacheStore
+}
+
+func (unavailableStore) Get(string, interface{}) error {
+	return errors.New("store unavailable")
+}
+
+func TestNamespace(t *testing.T) {
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+	global := NewNamespace("global", memoryStore)
+	users := NewNamespace("users", memoryStore)
+
+	calls := 0
+	engine := gin.New()
+	handler := func(c *gin.Context) {
+		calls++
+		c.String(http.StatusOK, "value")
+	}
+	engine.GET("/users", CacheByRequestURI(memoryStore, 3*time.Second, WithNamespace(global, users)), handler)
+	engine.GET("/items", CacheByRequestURI(memoryStore, 3*time.Second, WithNamespace(global)), handler)
+
+	serve := func(uri string) {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, uri, nil))
+	}
+
+	serve("/users")
+	serve("/items")
+	serve("/users")
+	serve("/items")
+	assert.Equal(t, 2, calls)
+
+	// bump a namespace
+	_, err := users.Bump()
+	require.NoError(t, err)
+	serve("/users")
+	serve("/items")
+	assert.Equal(t, 3, calls)
+
+	// bump globally
+	_, err = global.Bump()
+	require.NoError(t, err)
+	serve("/users")
+	serve("/items")
+	assert.Equal(t, 5, calls)
+}
+
+func TestNamespaceVersionShared(t *testing.T) {
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+	replica1 := NewNamespace("global", memoryStore, WithNamespaceRefreshInterval(10*time.Millisecond))
+	replica2 := NewNamespace("global", memoryStore, WithNamespaceRefreshInterval(10*time.Millisecond))
+
+	v1, err := replica1.Version()
+	require.NoError(t, err)
+	v2, err := replica2.Version()
+	require.NoError(t, err)
+	assert.Equal(t, v1, v2)
+
+	bumped, err := replica1.Bump()
+	require.NoError(t, err)
+	assert.NotEqual(t, v1, bumped)
+
+	time.Sleep(20 * time.Millisecond)
+	v2, err = replica2.Version()
+	require.NoError(t, err)
+	assert.Equal(t, bumped, v2)
+}
+
+func TestNamespaceStoreUnavailable(t *testing.T) {
+	ns := NewNamespace("global", unavailableStore{persist.NewMemoryStore(1 * time.Minute)})
+
+	calls := 0
+	engine := gin.New()
+	engine.GET("/cache", CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second, WithNamespace(ns)),
+		func(c *gin.Context) {
+			calls++
+			c.String(http.StatusOK, "value")
+		},
+	)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cache", nil))
+		assert.Equal(t, "value", w.Body.String())
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestNamespaceVersionNeverExpires(t *testing.T) {
+	// the version outlives the default expiration of the store
+	memoryStore := persist.NewMemoryStore(100 * time.Millisecond)
+	ns := NewNamespace("global", memoryStore, WithNamespaceRefreshInterval(10*time.Millisecond))
+
+	version, err := ns.Version()
+	require.NoError(t, err)
+	time.Sleep(300 * time.Millisecond)
+
+	again, err := ns.Version()
+	require.NoError(t, err)
+	assert.Equal(t, version, again)
+
+	var stored string
+	require.NoError(t, memoryStore.Get(ns.versionKey(), &stored))
+	assert.Equal(t, version, stored)
+}
+
+func TestNamespaceVersionTTL(t *testing.T) {
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+	ns := NewNamespace("global", memoryStore,
+		WithNamespaceRefreshInterval(10*time.Millisecond), WithNamespaceVersionTTL(100*time.Millisecond))
+
+	// a missing version is the initial one, it isn't written
+	version, err := ns.Version()
+	require.NoError(t, err)
+	assert.Equal(t, namespaceInitialVersion, version)
+	var stored string
+	assert.Equal(t, persist.ErrCacheMiss, memoryStore.Get(ns.versionKey(), &stored))
+
+	bumped, err := ns.Bump()
+	require.NoError(t, err)
+	assert.NotEqual(t, namespaceInitialVersion, bumped)
+	require.NoError(t, memoryStore.Get(ns.versionKey(), &stored))
+	assert.Equal(t, bumped, stored)
+
+	// the bumped version expires, the namespace reverts to the initial one
+	time.Sleep(200 * time.Millisecond)
+	version, err = ns.Version()
+	require.NoError(t, err)
+	assert.Equal(t, namespaceInitialVersion, version)
+}
+
+// blockingStore blocks Get until unblocked
+type blockingStore struct {
+	persist.CacheStore
+	blocked chan struct{}
+}
+
+func (s *blockingStore) Get(key string, value interface{}) error {
+	<-s.blocked
+	return s.CacheStore.Get(key, value)
+}
+
+func TestNamespaceRefreshDoesNotBlock(t *testing.T) {
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+	version, err := NewNamespace("global", memoryStore).Bump()
+	require.NoError(t, err)
+
+	store := &blockingStore{CacheStore: memoryStore, blocked: make(chan struct{})}
+	ns := NewNamespace("global", store, WithNamespaceRefreshInterval(10*time.Millisecond))
+
+	close(store.blocked)
+	_, err = ns.Version()
+	require.NoError(t, err)
+	store.blocked = make(chan struct{})
+	time.Sleep(20 * time.Millisecond)
+
+	// the refresh waits for the store, the other callers keep using the known version
+	refreshed := make(chan struct{})
+	go func() {
+		defer close(refreshed)
+		_, _ = ns.Version()
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan string)
+	go func() {
+		v, _ := ns.Version()
+		done <- v
+	}()
+	select {
+	case v := <-done:
+		assert.Equal(t, version, v)
+	case <-time.After(time.Second):
+		t.Fatal("Version should not wait for the refresh")
+	}
+
+	close(store.blocked)
+	<-refreshed
+}
+
+func TestNamespaceBumpDuringRefresh(t *testing.T) {
+	sharedStore := persist.NewMemoryStore(1 * time.Minute)
+	nsA := NewNamespace("global", sharedStore, WithNamespaceRefreshInterval(20*time.Millisecond))
+	nsB := NewNamespace("global", sharedStore, WithNamespaceRefreshInterval(20*time.Millisecond))
+
+	_, err := nsA.Version()
+	require.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+
+	// the refresh of the stale version waits while the namespace is bumped locally
+	nsA.fetchMu.Lock()
+	refreshed := make(chan struct{})
+	go func() {
+		defer close(refreshed)
+		_, _ = nsA.Version()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	_, err = nsA.Bump()
+	require.NoError(t, err)
+	nsA.fetchMu.Unlock()
+	<-refreshed
+
+	// the bumps of the other replicas are still seen
+	bumped, err := nsB.Bump()
+	require.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+
+	version, err := nsA.Version()
+	require.NoError(t, err)
+	assert.Equal(t, bumped, version)
+}
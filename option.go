@@ -1,29 +1,63 @@
 package cache
 
 import (
+	"net/http"
 	"time"
 
+	"github.com/chenyahui/gin-cache/persist"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Config contains all options
 type Config struct {
-	logger Logger
+	logger StructuredLogger
+	tracer trace.Tracer
 
 	getCacheStrategyByRequest GetCacheStrategyByRequest
 
-	hitCacheCallback  OnHitCacheCallback
-	missCacheCallback OnMissCacheCallback
+	hitCacheCallback    OnHitCacheCallback
+	missCacheCallback   OnMissCacheCallback
+	bypassCacheCallback OnBypassCacheCallback
 
 	beforeReplyWithCacheCallback BeforeReplyWithCacheCallback
 
-	singleFlightForgetTimeout time.Duration
-	shareSingleFlightCallback OnShareSingleFlightCallback
-
-	ignoreQueryOrder bool
-	prefixKey        string
-	withoutHeader    bool
-	discardHeaders   []string
+	eventHandlers []EventHandler
+
+	singleFlightForgetTimeout   time.Duration
+	shareSingleFlightCallback   OnShareSingleFlightCallback
+	singleFlightFailureCallback OnSingleFlightFailureCallback
+	detachedTimeout             time.Duration
+
+	fillLimiter               *FillLimiter
+	fillLimitWaitTimeout      time.Duration
+	fillLimitExceededCallback OnFillLimitExceededCallback
+	maxStale                  time.Duration
+
+	distributedLock DistributedLock
+
+	refreshAheadHandler http.Handler
+	refreshAheadFactor  float64
+
+	ignoreQueryOrder   bool
+	queryNormalization *QueryNormalization
+	paramNormalizer    ParamNormalizer
+	maxBodySize        int64
+	bodyCanonicalizer  BodyCanonicalizer
+	prefixKey          string
+	keyHasher          KeyHasher
+	namespaces         []*Namespace
+	routeIndex         *RouteIndex
+	privateCache       *PrivateCache
+
+	cacheabilityPolicy        CacheabilityPolicy
+	poisoningGuard            *PoisoningGuard
+	poisoningDetectedCallback OnPoisoningDetectedCallback
+	withoutHeader             bool
+
+	// perRequestHeaders are never stored or shared, the keys are canonical
+	perRequestHeaders     map[string]struct{}
+	regenerateHeadersFunc RegenerateHeadersCallback
 }
 
 func newConfigByOpts(opts ...Option) *Config {
@@ -31,8 +65,11 @@ func newConfigByOpts(opts ...Option) *Config {
 		logger:                       Discard{},
 		hitCacheCallback:             defaultHitCacheCallback,
 		missCacheCallback:            defaultMissCacheCallback,
+		bypassCacheCallback:          defaultBypassCacheCallback,
 		beforeReplyWithCacheCallback: defaultBeforeReplyWithCacheCallback,
 		shareSingleFlightCallback:    defaultShareSingleFlightCallback,
+		fillLimitExceededCallback:    defaultFillLimitExceededCallback,
+		poisoningDetectedCallback:    defaultPoisoningDetectedCallback,
 	}
 
 	for _, opt := range opts {
@@ -45,11 +82,12 @@ func newConfigByOpts(opts ...Option) *Config {
 // Option represents the optional function.
 type Option func(c *Config)
 
-// WithLogger set the custom logger
+// WithLogger set the custom logger, only the error logs are output to it.
+// Use WithStructuredLogger for the debug logs and the fields.
 func WithLogger(l Logger) Option {
 	return func(c *Config) {
 		if l != nil {
-			c.logger = l
+			c.logger = errorfLogger{logger: l}
 		}
 	}
 }
@@ -67,6 +105,26 @@ type Discard struct {
 func (l Discard) Errorf(string, ...interface{}) {
 }
 
+// Enabled returns false for all levels
+func (l Discard) Enabled(Level) bool {
+	return false
+}
+
+// Log will output the log at level
+func (l Discard) Log(Level, string, ...interface{}) {
+}
+
+// WithTracer set the opentelemetry tracer, spans of the cache lookup, the singleflight, the backend call
+// and the cache store writing and deleting are started as children of the span in c.Request.Context().
+// The attributes contain a hash of the cache key rather than the key itself.
+func WithTracer(tracer trace.Tracer) Option {
+	return func(c *Config) {
+		if tracer != nil {
+			c.tracer = tracer
+		}
+	}
+}
+
 // WithCacheStrategyByRequest set up the custom strategy by per request
 func WithCacheStrategyByRequest(getGetCacheStrategyByRequest GetCacheStrategyByRequest) Option {
 	return func(c *Config) {
@@ -81,7 +139,7 @@ type OnHitCacheCallback func(c *gin.Context)
 
 var defaultHitCacheCallback = func(c *gin.Context) {}
 
-// WithOnHitCache will be called when cache hit.
+// WithOnHitCache will be called when cache hit, it's the callback of EventHit.
 func WithOnHitCache(cb OnHitCacheCallback) Option {
 	return func(c *Config) {
 		if cb != nil {
@@ -95,7 +153,7 @@ type OnMissCacheCallback func(c *gin.Context)
 
 var defaultMissCacheCallback = func(c *gin.Context) {}
 
-// WithOnMissCache will be called when cache miss.
+// WithOnMissCache will be called when cache miss, it's the callback of EventMiss.
 func WithOnMissCache(cb OnMissCacheCallback) Option {
 	return func(c *Config) {
 		if cb != nil {
@@ -104,6 +162,20 @@ func WithOnMissCache(cb OnMissCacheCallback) Option {
 	}
 }
 
+// OnBypassCacheCallback define the callback when the request is not cached by the strategy
+type OnBypassCacheCallback func(c *gin.Context)
+
+var defaultBypassCacheCallback = func(c *gin.Context) {}
+
+// WithOnBypassCache will be called when the strategy decides not to cache the request, it's the callback of EventBypass.
+func WithOnBypassCache(cb OnBypassCacheCallback) Option {
+	return func(c *Config) {
+		if cb != nil {
+			c.bypassCacheCallback = cb
+		}
+	}
+}
+
 type BeforeReplyWithCacheCallback func(c *gin.Context, cache *ResponseCache)
 
 var defaultBeforeReplyWithCacheCallback = func(c *gin.Context, cache *ResponseCache) {}
@@ -122,7 +194,7 @@ type OnShareSingleFlightCallback func(c *gin.Context)
 
 var defaultShareSingleFlightCallback = func(c *gin.Context) {}
 
-// WithOnShareSingleFlight will be called when share the singleflight result
+// WithOnShareSingleFlight will be called when share the singleflight result, it's the callback of EventShared
 func WithOnShareSingleFlight(cb OnShareSingleFlightCallback) Option {
 	return func(c *Config) {
 		if cb != nil {
@@ -131,6 +203,68 @@ func WithOnShareSingleFlight(cb OnShareSingleFlightCallback) Option {
 	}
 }
 
+// OnSingleFlightFailureCallback define the callback when the response of singleflight leader can't be shared.
+// err is one of ErrSingleFlightLeaderPanic, ErrSingleFlightLeaderAborted, ErrSingleFlightLeaderCanceled
+// and ErrReflectedHeader.
+type OnSingleFlightFailureCallback func(c *gin.Context, err error)
+
+// WithOnSingleFlightFailure will be called for every request sharing the singleflight when the leader
+// panicked, aborted or its client has gone. The callback is responsible for the response, e.g. c.AbortWithStatus(503).
+// Without the callback, every sharing request calls its own handlers instead.
+func WithOnSingleFlightFailure(cb OnSingleFlightFailureCallback) Option {
+	return func(c *Config) {
+		if cb != nil {
+			c.singleFlightFailureCallback = cb
+		}
+	}
+}
+
+// WithDetachedContext run the handlers filling the cache with a context detached from the client connection,
+// bounded by timeout. If the client calling the backend goes away, the singleflight followers and the cache
+// still get the response. The handlers should use c.Request.Context() to observe the timeout.
+func WithDetachedContext(timeout time.Duration) Option {
+	return func(c *Config) {
+		if timeout > 0 {
+			c.detachedTimeout = timeout
+		}
+	}
+}
+
+// WithFillLimit bounds the concurrent backend calls on cache miss by limiter.
+// A request waits at most waitTimeout for a slot, then it's replied with the stale entry if WithServeStale is enabled,
+// otherwise with the callback of WithOnFillLimitExceeded, which responds 503 by default.
+// Strategy.FillLimiter overrides limiter per request.
+func WithFillLimit(limiter *FillLimiter, waitTimeout time.Duration) Option {
+	return func(c *Config) {
+		if limiter != nil {
+			c.fillLimiter = limiter
+		}
+		if waitTimeout > 0 {
+			c.fillLimitWaitTimeout = waitTimeout
+		}
+	}
+}
+
+// WithOnFillLimitExceeded will be called when the fill limit is exceeded and no stale entry exists.
+// The callback is responsible for the response.
+func WithOnFillLimitExceeded(cb OnFillLimitExceededCallback) Option {
+	return func(c *Config) {
+		if cb != nil {
+			c.fillLimitExceededCallback = cb
+		}
+	}
+}
+
+// WithServeStale keeps the entries in the store maxStale longer than their cache duration.
+// An expired entry is treated as a miss, but it's replied when the fill limit is exceeded.
+func WithServeStale(maxStale time.Duration) Option {
+	return func(c *Config) {
+		if maxStale > 0 {
+			c.maxStale = maxStale
+		}
+	}
+}
+
 // WithSingleFlightForgetTimeout to reduce the impact of long tail requests.
 // singleflight.Forget will be called after the timeout has reached for each backend request when timeout is greater than zero.
 func WithSingleFlightForgetTimeout(forgetTimeout time.Duration) Option {
@@ -141,6 +275,65 @@ func WithSingleFlightForgetTimeout(forgetTimeout time.Duration) Option {
 	}
 }
 
+const (
+	defaultDistributedLockTTL          = 10 * time.Second
+	defaultDistributedLockWaitTimeout  = 3 * time.Second
+	defaultDistributedLockPollInterval = 50 * time.Millisecond
+)
+
+// DistributedLock describe the lock shared by replicas, so that only one replica calls the backend on a cache miss
+type DistributedLock struct {
+	// Locker is usually the same store as the cache store, e.g. RedisStore
+	Locker persist.Locker
+
+	// LockTTL bounds how long a crashed replica can hold the lock, default 10s
+	LockTTL time.Duration
+
+	// WaitTimeout is how long the other replicas wait for the entry, then call the backend locally, default 3s
+	WaitTimeout time.Duration
+
+	// PollInterval is how often the other replicas check the cache store while waiting, default 50ms
+	PollInterval time.Duration
+}
+
+// WithDistributedLock extend singleflight across replicas.
+// On a cache miss, the replica acquiring the lock calls the backend, the others poll the cache store for the entry.
+func WithDistributedLock(lock DistributedLock) Option {
+	return func(c *Config) {
+		if lock.Locker == nil {
+			return
+		}
+
+		if lock.LockTTL <= 0 {
+			lock.LockTTL = defaultDistributedLockTTL
+		}
+		if lock.WaitTimeout <= 0 {
+			lock.WaitTimeout = defaultDistributedLockWaitTimeout
+		}
+		if lock.PollInterval <= 0 {
+			lock.PollInterval = defaultDistributedLockPollInterval
+		}
+		c.distributedLock = lock
+	}
+}
+
+// WithRefreshAhead recompute the entries which are still read when factor of their TTL has elapsed, e.g. 0.8.
+// The request is replayed in background through handler, which is usually the gin engine itself,
+// so the middlewares before the cache middleware also run for the replayed request.
+// Strategy.RefreshAheadFactor overrides factor per request.
+func WithRefreshAhead(handler http.Handler, factor float64) Option {
+	return func(c *Config) {
+		if handler == nil {
+			return
+		}
+
+		c.refreshAheadHandler = handler
+		if factor > 0 && factor < 1 {
+			c.refreshAheadFactor = factor
+		}
+	}
+}
+
 // IgnoreQueryOrder will ignore the queries order in url when generate cache key . This option only takes effect in CacheByRequestURI function
 func IgnoreQueryOrder() Option {
 	return func(c *Config) {
@@ -161,9 +354,45 @@ func WithoutHeader() Option {
 	}
 }
 
+// WithDiscardHeaders will not cache the headers, it's the same as WithPerRequestHeaders without regeneration
 func WithDiscardHeaders(headers []string) Option {
+	return WithPerRequestHeaders(headers, nil)
+}
+
+// RegenerateHeadersCallback set the per-request headers of a request replied by a cache hit or a shared singleflight response
+type RegenerateHeadersCallback func(c *gin.Context)
+
+// WithPerRequestHeaders declare the headers only meaningful to the request generating the response,
+// e.g. X-Request-Id or trace headers. They are neither cached nor shared with the singleflight followers,
+// so the values set by the middlewares before the cache middleware are kept.
+// regenerate is called before replying with a cached or shared response, to set fresh values for the request.
+func WithPerRequestHeaders(headers []string, regenerate RegenerateHeadersCallback) Option {
 	return func(c *Config) {
-		c.discardHeaders = headers
+		if c.perRequestHeaders == nil {
+			c.perRequestHeaders = make(map[string]struct{}, len(headers))
+		}
+		for _, header := range headers {
+			c.perRequestHeaders[http.CanonicalHeaderKey(header)] = struct{}{}
+		}
+
+		if regenerate != nil {
+			c.regenerateHeadersFunc = regenerate
+		}
+	}
+}
+
+// PerRequestHeaders the common headers which should be generated for every request.
+// Set-Cookie is included, so that the cookies of a user are never replayed to the others.
+func PerRequestHeaders() []string {
+	return []string{
+		"Set-Cookie",
+		"X-Request-Id",
+		"X-Correlation-Id",
+		"Traceparent",
+		"Tracestate",
+		"X-B3-Traceid",
+		"X-B3-Spanid",
+		"X-B3-Sampled",
 	}
 }
 
@@ -8,14 +8,40 @@ import (
 // ErrCacheMiss represent the cache key does not exist in the store
 var ErrCacheMiss = errors.New("persist cache miss error")
 
+// ErrItemTooLarge represent the serialized value exceeds the item size limit of the store
+var ErrItemTooLarge = errors.New("persist cache item too large")
+
+// NoExpiration passed to Set stores the item without expiration,
+// unlike zero which means the default expiration of the store, e.g. of MemoryStore
+const NoExpiration time.Duration = -1
+
 // CacheStore is the interface of a Cache backend
 type CacheStore interface {
 	// Get retrieves an item from the Cache. if key does not exist in the store, return ErrCacheMiss
 	Get(key string, value interface{}) error
 
 	// Set sets an item to the Cache, replacing any existing item.
+	// expire zero means the default expiration of the store, NoExpiration means never expire.
 	Set(key string, value interface{}, expire time.Duration) error
 
 	// Delete removes an item from the Cache. Does nothing if the key is not in the Cache.
 	Delete(key string) error
 }
+
+// Locker is implemented by the stores which can serve as a distributed lock
+type Locker interface {
+	// TryLock acquires the lock of key for ttl if nobody else holds it.
+	// The returned token identifies the holder for Unlock, it's not a fencing token,
+	// so a holder whose lock expired can't be prevented from writing.
+	TryLock(key string, ttl time.Duration) (token int64, acquired bool, err error)
+
+	// Unlock releases the lock of key, only if it is still held with token.
+	Unlock(key string, token int64) error
+}
+
+// EvictionNotifier is implemented by the stores which report the entries removed by expiration or capacity
+type EvictionNotifier interface {
+	// OnEvicted registers fn to be called with the key of every evicted entry, it's not called for Delete.
+	// fn may be called from another goroutine.
+	OnEvicted(fn func(key string))
+}
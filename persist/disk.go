@@ -0,0 +1,484 @@
+package persist
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCorruptedEntry represent the file of a disk store entry can't be decoded
+var ErrCorruptedEntry = errors.New("persist disk entry corrupted")
+
+const (
+	defaultDiskSweepInterval = time.Minute
+
+	diskEntryMagic     = "GCD1"
+	diskEntryHeaderLen = len(diskEntryMagic) + 8 + 4
+	diskEntryNameLen   = sha256.Size * 2
+	diskShardNameLen   = 2
+	diskTempFileSuffix = ".tmp"
+)
+
+// DiskOption represents the optional function of DiskStore
+type DiskOption func(store *DiskStore)
+
+// WithDiskMaxSize set the max total bytes of entries on disk, zero means unlimited.
+// Entries closest to expiration are evicted first when the size cap is exceeded.
+func WithDiskMaxSize(maxSize int64) DiskOption {
+	return func(store *DiskStore) {
+		if maxSize > 0 {
+			store.maxSize = maxSize
+		}
+	}
+}
+
+// WithDiskSweepInterval set the interval of the background sweeping of expired entries
+func WithDiskSweepInterval(interval time.Duration) DiskOption {
+	return func(store *DiskStore) {
+		if interval > 0 {
+			store.sweepInterval = interval
+		}
+	}
+}
+
+// DiskStore store http response in local files, so that the cache survives restarts.
+// Every entry is a file named by the sha256 of its key, sharded into 256 directories.
+// Writes go to a temporary file which is renamed into place, so a crash never leaves a partial entry.
+// Only the files of this layout are loaded or removed, the other files in the directory are left untouched.
+type DiskStore struct {
+	dir           string
+	maxSize       int64
+	sweepInterval time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]diskEntryMeta
+	totalSize int64
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+type diskEntryMeta struct {
+	size     int64
+	expireAt int64
+}
+
+func (meta diskEntryMeta) expired(now int64) bool {
+	return meta.expireAt > 0 && now >= meta.expireAt
+}
+
+// NewDiskStore open or create a disk store in dir, and start the background sweeping
+func NewDiskStore(dir string, opts ...DiskOption) (*DiskStore, error) {
+	store := &DiskStore{
+		dir:           dir,
+		sweepInterval: defaultDiskSweepInterval,
+		entries:       map[string]diskEntryMeta{},
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(store)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	go store.sweepLoop()
+
+	return store, nil
+}
+
+// Set put key value pair to disk, and expire after expireDuration
+func (store *DiskStore) Set(key string, value interface{}, expire time.Duration) error {
+	payload, err := Serialize(value)
+	if err != nil {
+		return err
+	}
+
+	var expireAt int64
+	if expire > 0 {
+		expireAt = time.Now().Add(expire).UnixNano()
+	}
+
+	data := encodeDiskEntry(key, expireAt, payload)
+	if store.maxSize > 0 && int64(len(data)) > store.maxSize {
+		return ErrItemTooLarge
+	}
+
+	name := diskEntryName(key)
+	path := store.entryPath(name)
+	if err := store.mkdirShard(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	tmpPath, err := writeTempFile(path, data)
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	if err := os.Rename(tmpPath, path); err != nil {
+		store.mu.Unlock()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	store.totalSize -= store.entries[name].size
+	store.entries[name] = diskEntryMeta{size: int64(len(data)), expireAt: expireAt}
+	store.totalSize += int64(len(data))
+	overflow := store.maxSize > 0 && store.totalSize > store.maxSize
+	store.mu.Unlock()
+
+	// persist the rename, otherwise a crash may lose the entry or bring back the old one
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	if overflow {
+		store.evict()
+	}
+	return nil
+}
+
+// Delete remove key on disk, do nothing if key doesn't exist
+func (store *DiskStore) Delete(key string) error {
+	return store.remove(diskEntryName(key))
+}
+
+// Get retrieves an item from disk, if key doesn't exist or has expired, return ErrCacheMiss
+func (store *DiskStore) Get(key string, value interface{}) error {
+	name := diskEntryName(key)
+
+	data, err := ioutil.ReadFile(store.entryPath(name))
+	if os.IsNotExist(err) {
+		return ErrCacheMiss
+	}
+	if err != nil {
+		return err
+	}
+
+	entryKey, expireAt, payload, err := decodeDiskEntry(data)
+	if err != nil {
+		_ = store.remove(name)
+		return err
+	}
+
+	if entryKey != key {
+		// sha256 collision, treat as missing
+		return ErrCacheMiss
+	}
+
+	if expireAt > 0 && time.Now().UnixNano() >= expireAt {
+		store.removeExpired(name)
+		return ErrCacheMiss
+	}
+
+	return Deserialize(payload, value)
+}
+
+// Size returns the total bytes of entries on disk
+func (store *DiskStore) Size() int64 {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.totalSize
+}
+
+// Sweep removes all the expired entries on disk
+func (store *DiskStore) Sweep() {
+	now := time.Now().UnixNano()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for name, meta := range store.entries {
+		if meta.expired(now) {
+			_ = store.removeLocked(name)
+		}
+	}
+}
+
+// Close stops the background sweeping, entries are kept on disk
+func (store *DiskStore) Close() error {
+	store.stopOnce.Do(func() {
+		close(store.stop)
+	})
+	<-store.done
+	return nil
+}
+
+func (store *DiskStore) sweepLoop() {
+	defer close(store.done)
+
+	ticker := time.NewTicker(store.sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			store.Sweep()
+		case <-store.stop:
+			return
+		}
+	}
+}
+
+// evict removes expired entries, then entries closest to expiration until the size cap is satisfied
+func (store *DiskStore) evict() {
+	store.Sweep()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.totalSize <= store.maxSize {
+		return
+	}
+
+	names := make([]string, 0, len(store.entries))
+	for name := range store.entries {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return diskEvictOrder(store.entries[names[i]]) < diskEvictOrder(store.entries[names[j]])
+	})
+
+	for _, name := range names {
+		if store.totalSize <= store.maxSize {
+			break
+		}
+		_ = store.removeLocked(name)
+	}
+}
+
+func diskEvictOrder(meta diskEntryMeta) int64 {
+	if meta.expireAt == 0 {
+		// never expire, evict last
+		return 1<<63 - 1
+	}
+	return meta.expireAt
+}
+
+// removeExpired removes the entry only if it's still expired, it may have been rewritten concurrently
+func (store *DiskStore) removeExpired(name string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if meta, ok := store.entries[name]; ok && meta.expired(time.Now().UnixNano()) {
+		_ = store.removeLocked(name)
+	}
+}
+
+func (store *DiskStore) remove(name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.removeLocked(name)
+}
+
+func (store *DiskStore) removeLocked(name string) error {
+	err := os.Remove(store.entryPath(name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if meta, ok := store.entries[name]; ok {
+		store.totalSize -= meta.size
+		delete(store.entries, name)
+	}
+	return nil
+}
+
+// load rebuilds the index from the entries on disk, dropping leftovers of interrupted writes.
+// Only the shard directories and the entry files are visited, the other files are ignored.
+func (store *DiskStore) load() error {
+	now := time.Now().UnixNano()
+
+	shards, err := ioutil.ReadDir(store.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, shard := range shards {
+		if !shard.IsDir() || len(shard.Name()) != diskShardNameLen || !isLowerHex(shard.Name()) {
+			continue
+		}
+
+		shardDir := filepath.Join(store.dir, shard.Name())
+		files, err := ioutil.ReadDir(shardDir)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range files {
+			name := info.Name()
+			if info.IsDir() || !isDiskEntryFile(shard.Name(), name) {
+				continue
+			}
+
+			path := filepath.Join(shardDir, name)
+			if strings.HasSuffix(name, diskTempFileSuffix) {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				continue
+			}
+
+			expireAt, err := readDiskEntryExpireAt(path)
+			if err != nil {
+				return err
+			}
+
+			meta := diskEntryMeta{size: info.Size(), expireAt: expireAt}
+			if expireAt < 0 || meta.expired(now) {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				continue
+			}
+
+			store.entries[name] = meta
+			store.totalSize += info.Size()
+		}
+	}
+	return nil
+}
+
+// isDiskEntryFile returns whether name in shard is an entry file, or a temporary file of an entry,
+// i.e. the sha256 hex of the key beginning with shard, followed by the temporary suffix if any
+func isDiskEntryFile(shard, name string) bool {
+	if len(name) < diskEntryNameLen || !strings.HasPrefix(name, shard) || !isLowerHex(name[:diskEntryNameLen]) {
+		return false
+	}
+
+	rest := name[diskEntryNameLen:]
+	return rest == "" || (strings.HasPrefix(rest, ".") && strings.HasSuffix(rest, diskTempFileSuffix))
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// mkdirShard creates the shard directory if missing, and persists its entry in the store directory
+func (store *DiskStore) mkdirShard(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+
+	if err := os.Mkdir(dir, 0o755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return syncDir(store.dir)
+}
+
+// syncDir flushes the entries of dir, so that the files created or renamed in it survive a crash
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		// directories can't be opened for sync on windows, renames are durable there
+		return nil
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// readDiskEntryExpireAt only reads the header of the entry file, returns -1 if the header is corrupted
+func readDiskEntryExpireAt(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	header := make([]byte, diskEntryHeaderLen)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[:len(diskEntryMagic)]) != diskEntryMagic {
+		return -1, nil
+	}
+	return int64(binary.BigEndian.Uint64(header[len(diskEntryMagic):])), nil
+}
+
+func (store *DiskStore) entryPath(name string) string {
+	return filepath.Join(store.dir, name[:2], name)
+}
+
+func diskEntryName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// encodeDiskEntry layout: magic | expireAt int64 | key length uint32 | key | payload
+func encodeDiskEntry(key string, expireAt int64, payload []byte) []byte {
+	var b bytes.Buffer
+	b.Grow(diskEntryHeaderLen + len(key) + len(payload))
+
+	b.WriteString(diskEntryMagic)
+	_ = binary.Write(&b, binary.BigEndian, expireAt)
+	_ = binary.Write(&b, binary.BigEndian, uint32(len(key)))
+	b.WriteString(key)
+	b.Write(payload)
+	return b.Bytes()
+}
+
+func decodeDiskEntry(data []byte) (key string, expireAt int64, payload []byte, err error) {
+	if len(data) < diskEntryHeaderLen || string(data[:len(diskEntryMagic)]) != diskEntryMagic {
+		return "", 0, nil, ErrCorruptedEntry
+	}
+	data = data[len(diskEntryMagic):]
+
+	expireAt = int64(binary.BigEndian.Uint64(data[:8]))
+	keyLen := int(binary.BigEndian.Uint32(data[8:12]))
+	data = data[12:]
+
+	if len(data) < keyLen {
+		return "", 0, nil, ErrCorruptedEntry
+	}
+	return string(data[:keyLen]), expireAt, data[keyLen:], nil
+}
+
+// writeTempFile write data to a synced temporary file next to path, the caller renames it into place
+func writeTempFile(path string, data []byte) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*"+diskTempFileSuffix)
+	if err != nil {
+		return "", err
+	}
+
+	tmpPath := f.Name()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	return tmpPath, nil
+}
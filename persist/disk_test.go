@@ -0,0 +1,184 @@
+package persist
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDiskDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gin-cache-disk")
+	require.NoError(t, err)
+	return dir
+}
+
+func TestDiskStore(t *testing.T) {
+	dir := newTestDiskDir(t)
+	defer os.RemoveAll(dir)
+
+	diskStore, err := NewDiskStore(dir)
+	require.NoError(t, err)
+	defer diskStore.Close()
+
+	expectVal := "123"
+	require.Nil(t, diskStore.Set("test", expectVal, 1*time.Second))
+
+	value := ""
+	assert.Nil(t, diskStore.Get("test", &value))
+	assert.Equal(t, expectVal, value)
+
+	require.Nil(t, diskStore.Delete("test"))
+	assert.Equal(t, ErrCacheMiss, diskStore.Get("test", &value))
+	assert.Nil(t, diskStore.Delete("test"))
+
+	require.Nil(t, diskStore.Set("expire", expectVal, 1*time.Second))
+	time.Sleep(1 * time.Second)
+	assert.Equal(t, ErrCacheMiss, diskStore.Get("expire", &value))
+	assert.Equal(t, int64(0), diskStore.Size())
+}
+
+func TestDiskStoreReopen(t *testing.T) {
+	dir := newTestDiskDir(t)
+	defer os.RemoveAll(dir)
+
+	diskStore, err := NewDiskStore(dir)
+	require.NoError(t, err)
+	require.Nil(t, diskStore.Set("keep", "value", time.Minute))
+	require.Nil(t, diskStore.Set("expire", "value", 100*time.Millisecond))
+	require.Nil(t, diskStore.Close())
+
+	// leftover of an interrupted write
+	name := diskEntryName("interrupted")
+	leftover := filepath.Join(dir, name[:2], name+".123"+diskTempFileSuffix)
+	require.NoError(t, os.MkdirAll(filepath.Dir(leftover), 0o755))
+	require.NoError(t, ioutil.WriteFile(leftover, []byte("partial"), 0o644))
+
+	time.Sleep(200 * time.Millisecond)
+
+	reopened, err := NewDiskStore(dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	value := ""
+	assert.Nil(t, reopened.Get("keep", &value))
+	assert.Equal(t, "value", value)
+	assert.Equal(t, ErrCacheMiss, reopened.Get("expire", &value))
+
+	_, err = os.Stat(leftover)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDiskStoreKeepsUnrelatedFiles(t *testing.T) {
+	dir := newTestDiskDir(t)
+	defer os.RemoveAll(dir)
+
+	unrelated := []string{
+		filepath.Join(dir, "notes.txt"),
+		filepath.Join(dir, "00", "notes.txt"),
+		filepath.Join(dir, "00", "backup.tmp"),
+		filepath.Join(dir, "data", diskEntryName("a")),
+	}
+	for _, path := range unrelated {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, ioutil.WriteFile(path, []byte("not an entry"), 0o644))
+	}
+
+	// an entry file which can't be decoded is dropped
+	name := diskEntryName("corrupted")
+	corrupted := filepath.Join(dir, name[:2], name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(corrupted), 0o755))
+	require.NoError(t, ioutil.WriteFile(corrupted, []byte("garbage"), 0o644))
+
+	diskStore, err := NewDiskStore(dir)
+	require.NoError(t, err)
+	defer diskStore.Close()
+
+	for _, path := range unrelated {
+		_, err := os.Stat(path)
+		assert.NoError(t, err, path)
+	}
+	_, err = os.Stat(corrupted)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, int64(0), diskStore.Size())
+}
+
+func TestDiskStoreSweep(t *testing.T) {
+	dir := newTestDiskDir(t)
+	defer os.RemoveAll(dir)
+
+	diskStore, err := NewDiskStore(dir, WithDiskSweepInterval(50*time.Millisecond))
+	require.NoError(t, err)
+	defer diskStore.Close()
+
+	require.Nil(t, diskStore.Set("expire", "value", 100*time.Millisecond))
+	assert.True(t, diskStore.Size() > 0)
+
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, int64(0), diskStore.Size())
+
+	_, err = os.Stat(diskStore.entryPath(diskEntryName("expire")))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDiskStoreNoExpiration(t *testing.T) {
+	dir := newTestDiskDir(t)
+	defer os.RemoveAll(dir)
+
+	diskStore, err := NewDiskStore(dir, WithDiskSweepInterval(50*time.Millisecond))
+	require.NoError(t, err)
+	defer diskStore.Close()
+
+	require.Nil(t, diskStore.Set("forever", "value", NoExpiration))
+	require.Nil(t, diskStore.Set("default", "value", 0))
+	time.Sleep(100 * time.Millisecond)
+
+	value := ""
+	assert.Nil(t, diskStore.Get("forever", &value))
+	assert.Nil(t, diskStore.Get("default", &value))
+}
+
+func TestDiskStoreMaxSize(t *testing.T) {
+	dir := newTestDiskDir(t)
+	defer os.RemoveAll(dir)
+
+	value := strings.Repeat("a", 100)
+	payload, err := Serialize(value)
+	require.NoError(t, err)
+	entrySize := int64(len(encodeDiskEntry("key_0", 0, payload)))
+
+	diskStore, err := NewDiskStore(dir, WithDiskMaxSize(entrySize*2))
+	require.NoError(t, err)
+	defer diskStore.Close()
+
+	require.Nil(t, diskStore.Set("key_0", value, 1*time.Minute))
+	require.Nil(t, diskStore.Set("key_1", value, 3*time.Minute))
+	require.Nil(t, diskStore.Set("key_2", value, 2*time.Minute))
+	assert.True(t, diskStore.Size() <= entrySize*2)
+
+	// the entry closest to expiration is evicted
+	var dest string
+	assert.Equal(t, ErrCacheMiss, diskStore.Get("key_0", &dest))
+	assert.Nil(t, diskStore.Get("key_1", &dest))
+	assert.Nil(t, diskStore.Get("key_2", &dest))
+
+	assert.Equal(t, ErrItemTooLarge, diskStore.Set("large", strings.Repeat(value, 3), time.Minute))
+}
+
+func TestDiskEntryCodec(t *testing.T) {
+	data := encodeDiskEntry("key", 42, []byte("payload"))
+
+	key, expireAt, payload, err := decodeDiskEntry(data)
+	require.NoError(t, err)
+	assert.Equal(t, "key", key)
+	assert.Equal(t, int64(42), expireAt)
+	assert.Equal(t, []byte("payload"), payload)
+
+	_, _, _, err = decodeDiskEntry(data[:5])
+	assert.Equal(t, ErrCorruptedEntry, err)
+}
@@ -0,0 +1,235 @@
+package persist
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Operation is the name of a CacheStore method
+type Operation string
+
+// the operations of InstrumentedStore
+const (
+	OperationGet    Operation = "get"
+	OperationSet    Operation = "set"
+	OperationDelete Operation = "delete"
+)
+
+// Sizer is implemented by the values which know their payload size, e.g. cache.ResponseCache
+type Sizer interface {
+	Size() int
+}
+
+// Observation describe a finished operation of InstrumentedStore
+type Observation struct {
+	Operation Operation
+	Key       string
+	Latency   time.Duration
+
+	// Size is the payload size written by Set or read by Get, zero if unknown
+	Size int
+
+	// Miss is true if Get returned ErrCacheMiss, which is not counted as a failure
+	Miss bool
+
+	// Err is the error returned by the underlying store, ErrCacheMiss included
+	Err error
+}
+
+// OperationStats is the accumulated stats of an operation
+type OperationStats struct {
+	Calls    int64
+	Misses   int64
+	Failures int64
+
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+
+	// Bytes is the total payload size, only counted for the successful calls
+	Bytes int64
+}
+
+// AverageLatency returns the mean latency of the calls
+func (s OperationStats) AverageLatency() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Calls)
+}
+
+// InstrumentedStats is a snapshot of InstrumentedStore
+type InstrumentedStats struct {
+	Get    OperationStats
+	Set    OperationStats
+	Delete OperationStats
+}
+
+// InstrumentedOption represents the optional function of InstrumentedStore
+type InstrumentedOption func(store *InstrumentedStore)
+
+// WithObservationHook add a hook called synchronously after every operation, e.g. to export the metrics.
+// It can be used multiple times.
+func WithObservationHook(hook func(observation Observation)) InstrumentedOption {
+	return func(store *InstrumentedStore) {
+		if hook != nil {
+			store.hooks = append(store.hooks, hook)
+		}
+	}
+}
+
+// WithPayloadSizer set the function measuring the values, it replaces the default one,
+// which supports Sizer, []byte and string.
+func WithPayloadSizer(sizer func(value interface{}) int) InstrumentedOption {
+	return func(store *InstrumentedStore) {
+		if sizer != nil {
+			store.sizer = sizer
+		}
+	}
+}
+
+// InstrumentedStore wraps a CacheStore, it records the counts, latencies, error classes and payload sizes
+// of every operation. Use Stats to read the numbers, or the hooks to export them.
+type InstrumentedStore struct {
+	store CacheStore
+	hooks []func(observation Observation)
+	sizer func(value interface{}) int
+
+	mu    sync.Mutex
+	stats InstrumentedStats
+}
+
+// NewInstrumentedStore wraps store with instrumentation
+func NewInstrumentedStore(store CacheStore, opts ...InstrumentedOption) *InstrumentedStore {
+	instrumented := &InstrumentedStore{
+		store: store,
+		sizer: payloadSize,
+	}
+
+	for _, opt := range opts {
+		opt(instrumented)
+	}
+
+	return instrumented
+}
+
+// Get retrieves an item from the underlying store
+func (store *InstrumentedStore) Get(key string, value interface{}) error {
+	start := time.Now()
+	err := store.store.Get(key, value)
+
+	size := 0
+	if err == nil {
+		size = store.sizer(value)
+	}
+	store.observe(OperationGet, key, start, size, err)
+	return err
+}
+
+// Set sets an item to the underlying store
+func (store *InstrumentedStore) Set(key string, value interface{}, expire time.Duration) error {
+	start := time.Now()
+	err := store.store.Set(key, value, expire)
+	store.observe(OperationSet, key, start, store.sizer(value), err)
+	return err
+}
+
+// Delete removes an item from the underlying store
+func (store *InstrumentedStore) Delete(key string) error {
+	start := time.Now()
+	err := store.store.Delete(key)
+	store.observe(OperationDelete, key, start, 0, err)
+	return err
+}
+
+// Stats returns a snapshot of the accumulated stats
+func (store *InstrumentedStore) Stats() InstrumentedStats {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.stats
+}
+
+// Reset clears the accumulated stats
+func (store *InstrumentedStore) Reset() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.stats = InstrumentedStats{}
+}
+
+// Unwrap returns the underlying store, e.g. to use it as a Locker
+func (store *InstrumentedStore) Unwrap() CacheStore {
+	return store.store
+}
+
+func (store *InstrumentedStore) observe(operation Operation, key string, start time.Time, size int, err error) {
+	observation := Observation{
+		Operation: operation,
+		Key:       key,
+		Latency:   time.Since(start),
+		Size:      size,
+		Miss:      errors.Is(err, ErrCacheMiss),
+		Err:       err,
+	}
+
+	store.mu.Lock()
+	var stats *OperationStats
+	switch operation {
+	case OperationGet:
+		stats = &store.stats.Get
+	case OperationSet:
+		stats = &store.stats.Set
+	default:
+		stats = &store.stats.Delete
+	}
+
+	stats.Calls++
+	stats.TotalLatency += observation.Latency
+	if observation.Latency > stats.MaxLatency {
+		stats.MaxLatency = observation.Latency
+	}
+	switch {
+	case observation.Miss:
+		stats.Misses++
+	case err != nil:
+		stats.Failures++
+	default:
+		stats.Bytes += int64(size)
+	}
+	store.mu.Unlock()
+
+	for _, hook := range store.hooks {
+		hook(observation)
+	}
+}
+
+// payloadSize measures value, which may be a pointer to the Sizer as Get is called with
+func payloadSize(value interface{}) int {
+	for value != nil {
+		rv := reflect.ValueOf(value)
+		if rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return 0
+		}
+
+		switch v := value.(type) {
+		case Sizer:
+			return v.Size()
+		case []byte:
+			return len(v)
+		case string:
+			return len(v)
+		case *[]byte:
+			return len(*v)
+		case *string:
+			return len(*v)
+		}
+
+		if rv.Kind() != reflect.Ptr {
+			return 0
+		}
+		value = rv.Elem().Interface()
+	}
+	return 0
+}
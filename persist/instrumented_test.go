@@ -0,0 +1,77 @@
+package persist
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingStore struct {
+	*MemoryStore
+}
+
+func (s failingStore) Delete(string) error {
+	return errors.New("delete failed")
+}
+
+type sizedValue struct {
+	Data []byte
+}
+
+func (v *sizedValue) Size() int {
+	return len(v.Data)
+}
+
+func TestInstrumentedStore(t *testing.T) {
+	var observations []Observation
+	store := NewInstrumentedStore(failingStore{NewMemoryStore(1 * time.Minute)},
+		WithObservationHook(func(observation Observation) {
+			observations = append(observations, observation)
+		}),
+	)
+
+	value := &sizedValue{Data: []byte("value")}
+	require.Nil(t, store.Set("key", value, 1*time.Minute))
+
+	var got *sizedValue
+	require.Nil(t, store.Get("key", &got))
+	assert.Equal(t, value, got)
+
+	assert.Equal(t, ErrCacheMiss, store.Get("missing", &got))
+	assert.EqualError(t, store.Delete("key"), "delete failed")
+
+	stats := store.Stats()
+	assert.Equal(t, int64(2), stats.Get.Calls)
+	assert.Equal(t, int64(1), stats.Get.Misses)
+	assert.Equal(t, int64(0), stats.Get.Failures)
+	assert.Equal(t, int64(len("value")), stats.Get.Bytes)
+	assert.Equal(t, int64(1), stats.Set.Calls)
+	assert.Equal(t, int64(len("value")), stats.Set.Bytes)
+	assert.Equal(t, int64(1), stats.Delete.Failures)
+	assert.True(t, stats.Get.MaxLatency >= stats.Get.AverageLatency())
+
+	require.Len(t, observations, 4)
+	assert.Equal(t, OperationSet, observations[0].Operation)
+	assert.Equal(t, len("value"), observations[0].Size)
+	assert.True(t, observations[2].Miss)
+	assert.Equal(t, OperationDelete, observations[3].Operation)
+	assert.NotNil(t, observations[3].Err)
+
+	store.Reset()
+	assert.Equal(t, InstrumentedStats{}, store.Stats())
+}
+
+func TestPayloadSize(t *testing.T) {
+	assert.Equal(t, 3, payloadSize([]byte("abc")))
+	assert.Equal(t, 3, payloadSize("abc"))
+
+	s := "abcd"
+	assert.Equal(t, 4, payloadSize(&s))
+
+	var nilValue *sizedValue
+	assert.Equal(t, 0, payloadSize(&nilValue))
+	assert.Equal(t, 0, payloadSize(42))
+}
@@ -0,0 +1,423 @@
+package persist
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrNoServers represent there is no memcached server configured
+var ErrNoServers = errors.New("persist memcached no servers configured")
+
+const (
+	defaultMemcachedTimeout      = 500 * time.Millisecond
+	defaultMemcachedMaxIdleConns = 8
+	defaultMemcachedMaxItemSize  = 1024 * 1024
+	defaultMemcachedVirtualNodes = 160
+
+	// memcached treats an expiration greater than 30 days as an absolute unix timestamp
+	memcachedRelativeExpireLimit = 30 * 24 * time.Hour
+
+	memcachedMaxKeyLength = 250
+)
+
+// MemcachedOption represents the optional function of MemcachedStore
+type MemcachedOption func(store *MemcachedStore)
+
+// WithMemcachedTimeout set the dial and read/write timeout of every command
+func WithMemcachedTimeout(timeout time.Duration) MemcachedOption {
+	return func(store *MemcachedStore) {
+		if timeout > 0 {
+			store.timeout = timeout
+		}
+	}
+}
+
+// WithMemcachedMaxIdleConns set the max idle connections kept for each server
+func WithMemcachedMaxIdleConns(maxIdleConns int) MemcachedOption {
+	return func(store *MemcachedStore) {
+		if maxIdleConns > 0 {
+			store.maxIdleConns = maxIdleConns
+		}
+	}
+}
+
+// WithMemcachedMaxItemSize set the item size limit, it should match the -I option of memcached.
+// Set will return ErrItemTooLarge without contacting the server if the payload exceeds the limit.
+func WithMemcachedMaxItemSize(maxItemSize int) MemcachedOption {
+	return func(store *MemcachedStore) {
+		if maxItemSize > 0 {
+			store.maxItemSize = maxItemSize
+		}
+	}
+}
+
+// MemcachedStore store http response in memcached, speaking the memcached text protocol.
+// Keys are distributed across servers by consistent hashing.
+type MemcachedStore struct {
+	timeout      time.Duration
+	maxIdleConns int
+	maxItemSize  int
+
+	ring  []memcachedRingNode
+	pools map[string]*memcachedConnPool
+}
+
+type memcachedRingNode struct {
+	hash uint32
+	addr string
+}
+
+// NewMemcachedStore create a memcached store with the server addresses, e.g. "127.0.0.1:11211"
+func NewMemcachedStore(servers []string, opts ...MemcachedOption) (*MemcachedStore, error) {
+	if len(servers) == 0 {
+		return nil, ErrNoServers
+	}
+
+	store := &MemcachedStore{
+		timeout:      defaultMemcachedTimeout,
+		maxIdleConns: defaultMemcachedMaxIdleConns,
+		maxItemSize:  defaultMemcachedMaxItemSize,
+		pools:        make(map[string]*memcachedConnPool, len(servers)),
+	}
+
+	for _, opt := range opts {
+		opt(store)
+	}
+
+	for _, addr := range servers {
+		if _, ok := store.pools[addr]; ok {
+			continue
+		}
+
+		store.pools[addr] = &memcachedConnPool{
+			addr:    addr,
+			timeout: store.timeout,
+			idle:    make(chan *memcachedConn, store.maxIdleConns),
+		}
+
+		for i := 0; i < defaultMemcachedVirtualNodes; i++ {
+			store.ring = append(store.ring, memcachedRingNode{
+				hash: crc32.ChecksumIEEE([]byte(addr + "#" + strconv.Itoa(i))),
+				addr: addr,
+			})
+		}
+	}
+
+	sort.Slice(store.ring, func(i, j int) bool {
+		return store.ring[i].hash < store.ring[j].hash
+	})
+
+	return store, nil
+}
+
+// Set put key value pair to memcached, and expire after expireDuration
+func (store *MemcachedStore) Set(key string, value interface{}, expire time.Duration) error {
+	payload, err := Serialize(value)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > store.maxItemSize {
+		return ErrItemTooLarge
+	}
+
+	key = memcachedKey(key)
+	return store.do(key, func(rw *bufio.ReadWriter) error {
+		if _, err := fmt.Fprintf(rw, "set %s 0 %d %d\r\n", key, memcachedExpiration(expire), len(payload)); err != nil {
+			return err
+		}
+		if _, err := rw.Write(payload); err != nil {
+			return err
+		}
+		if _, err := rw.WriteString("\r\n"); err != nil {
+			return err
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+
+		line, err := readMemcachedLine(rw.Reader)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case bytes.Equal(line, []byte("STORED")):
+			return nil
+		case bytes.Contains(line, []byte("too large")):
+			return ErrItemTooLarge
+		default:
+			return memcachedResponseError(line)
+		}
+	})
+}
+
+// Delete remove key in memcached, do nothing if key doesn't exist
+func (store *MemcachedStore) Delete(key string) error {
+	key = memcachedKey(key)
+	return store.do(key, func(rw *bufio.ReadWriter) error {
+		if _, err := fmt.Fprintf(rw, "delete %s\r\n", key); err != nil {
+			return err
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+
+		line, err := readMemcachedLine(rw.Reader)
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(line, []byte("DELETED")) || bytes.Equal(line, []byte("NOT_FOUND")) {
+			return nil
+		}
+		return memcachedResponseError(line)
+	})
+}
+
+// Get retrieves an item from memcached, if key doesn't exist, return ErrCacheMiss
+func (store *MemcachedStore) Get(key string, value interface{}) error {
+	key = memcachedKey(key)
+
+	var payload []byte
+	err := store.do(key, func(rw *bufio.ReadWriter) error {
+		if _, err := fmt.Fprintf(rw, "get %s\r\n", key); err != nil {
+			return err
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+
+		line, err := readMemcachedLine(rw.Reader)
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(line, []byte("END")) {
+			return ErrCacheMiss
+		}
+
+		// VALUE <key> <flags> <bytes>
+		fields := bytes.Fields(line)
+		if len(fields) != 4 || !bytes.Equal(fields[0], []byte("VALUE")) {
+			return memcachedResponseError(line)
+		}
+
+		size, err := strconv.Atoi(string(fields[3]))
+		if err != nil {
+			return memcachedResponseError(line)
+		}
+
+		payload = make([]byte, size+2)
+		if _, err := io.ReadFull(rw, payload); err != nil {
+			return err
+		}
+		payload = payload[:size]
+
+		line, err = readMemcachedLine(rw.Reader)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(line, []byte("END")) {
+			return memcachedResponseError(line)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return Deserialize(payload, value)
+}
+
+// Close closes all the idle connections
+func (store *MemcachedStore) Close() error {
+	for _, pool := range store.pools {
+		pool.close()
+	}
+	return nil
+}
+
+// do pick the server of key, and run the command on a pooled connection
+func (store *MemcachedStore) do(key string, cmd func(rw *bufio.ReadWriter) error) error {
+	pool := store.pools[store.pickServer(key)]
+
+	conn, err := pool.get()
+	if err != nil {
+		return err
+	}
+
+	if err := conn.nc.SetDeadline(time.Now().Add(store.timeout)); err != nil {
+		_ = conn.nc.Close()
+		return err
+	}
+
+	err = cmd(conn.rw)
+	if err != nil && !isMemcachedResumableError(err) {
+		// the connection state is unknown, never return it to the pool
+		_ = conn.nc.Close()
+		return err
+	}
+
+	pool.put(conn)
+	return err
+}
+
+func (store *MemcachedStore) pickServer(key string) string {
+	if len(store.ring) == 0 {
+		return ""
+	}
+
+	h := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(store.ring), func(i int) bool {
+		return store.ring[i].hash >= h
+	})
+	if idx == len(store.ring) {
+		idx = 0
+	}
+	return store.ring[idx].addr
+}
+
+type memcachedConn struct {
+	nc net.Conn
+	rw *bufio.ReadWriter
+}
+
+type memcachedConnPool struct {
+	addr    string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	closed bool
+	idle   chan *memcachedConn
+}
+
+func (p *memcachedConnPool) get() (*memcachedConn, error) {
+	select {
+	case conn := <-p.idle:
+		return conn, nil
+	default:
+	}
+
+	nc, err := net.DialTimeout("tcp", p.addr, p.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &memcachedConn{
+		nc: nc,
+		rw: bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc)),
+	}, nil
+}
+
+func (p *memcachedConnPool) put(conn *memcachedConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		_ = conn.nc.Close()
+		return
+	}
+
+	select {
+	case p.idle <- conn:
+	default:
+		_ = conn.nc.Close()
+	}
+}
+
+func (p *memcachedConnPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+
+	for {
+		select {
+		case conn := <-p.idle:
+			_ = conn.nc.Close()
+		default:
+			return
+		}
+	}
+}
+
+// memcachedProtocolError represent an error reply of memcached, the connection is still usable
+type memcachedProtocolError struct {
+	line string
+}
+
+func (e *memcachedProtocolError) Error() string {
+	return "persist memcached: " + e.line
+}
+
+func memcachedResponseError(line []byte) error {
+	return &memcachedProtocolError{line: string(line)}
+}
+
+func isMemcachedResumableError(err error) bool {
+	if errors.Is(err, ErrCacheMiss) || errors.Is(err, ErrItemTooLarge) {
+		return true
+	}
+
+	var protocolErr *memcachedProtocolError
+	if errors.As(err, &protocolErr) {
+		// after a malformed reply we can't tell where the next reply starts
+		return bytes.HasPrefix([]byte(protocolErr.line), []byte("SERVER_ERROR")) ||
+			bytes.HasPrefix([]byte(protocolErr.line), []byte("CLIENT_ERROR"))
+	}
+	return false
+}
+
+func readMemcachedLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// memcachedExpiration convert the duration to the exptime of memcached protocol
+func memcachedExpiration(expire time.Duration) int64 {
+	if expire <= 0 {
+		return 0
+	}
+
+	if expire > memcachedRelativeExpireLimit {
+		return time.Now().Add(expire).Unix()
+	}
+
+	// round up, an exptime of zero means never expire
+	return int64((expire + time.Second - 1) / time.Second)
+}
+
+// memcachedKey replace the key with its sha1 digest if it is not a valid memcached key
+func memcachedKey(key string) string {
+	valid := len(key) > 0 && len(key) <= memcachedMaxKeyLength
+	for i := 0; valid && i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			valid = false
+		}
+	}
+
+	if valid {
+		return key
+	}
+
+	sum := sha1.Sum([]byte(key))
+	return "sha1:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,251 @@
+package persist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeMemcachedItem struct {
+	data     []byte
+	expireAt time.Time
+}
+
+// fakeMemcached is an in-process server speaking a subset of the memcached text protocol
+type fakeMemcached struct {
+	listener    net.Listener
+	maxItemSize int
+
+	mu    sync.Mutex
+	items map[string]fakeMemcachedItem
+	conns int
+}
+
+func newFakeMemcached(t *testing.T, maxItemSize int) *fakeMemcached {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &fakeMemcached{
+		listener:    listener,
+		maxItemSize: maxItemSize,
+		items:       map[string]fakeMemcachedItem{},
+	}
+	go server.serve()
+	return server
+}
+
+func (s *fakeMemcached) close() {
+	_ = s.listener.Close()
+}
+
+func (s *fakeMemcached) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeMemcached) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.items))
+	for key := range s.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s *fakeMemcached) connCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+func (s *fakeMemcached) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeMemcached) handle(conn net.Conn) {
+	defer conn.Close()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+
+		switch fields[0] {
+		case "get":
+			s.mu.Lock()
+			item, ok := s.items[fields[1]]
+			if ok && !item.expireAt.IsZero() && time.Now().After(item.expireAt) {
+				delete(s.items, fields[1])
+				ok = false
+			}
+			s.mu.Unlock()
+
+			if ok {
+				fmt.Fprintf(rw, "VALUE %s 0 %d\r\n", fields[1], len(item.data))
+				rw.Write(item.data)
+				rw.WriteString("\r\n")
+			}
+			rw.WriteString("END\r\n")
+		case "set":
+			exptime, _ := strconv.Atoi(fields[3])
+			size, _ := strconv.Atoi(fields[4])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, data); err != nil {
+				return
+			}
+
+			if size > s.maxItemSize {
+				rw.WriteString("SERVER_ERROR object too large for cache\r\n")
+				break
+			}
+
+			item := fakeMemcachedItem{data: data[:size]}
+			if exptime > 0 {
+				item.expireAt = time.Now().Add(time.Duration(exptime) * time.Second)
+			}
+
+			s.mu.Lock()
+			s.items[fields[1]] = item
+			s.mu.Unlock()
+			rw.WriteString("STORED\r\n")
+		case "delete":
+			s.mu.Lock()
+			_, ok := s.items[fields[1]]
+			delete(s.items, fields[1])
+			s.mu.Unlock()
+
+			if ok {
+				rw.WriteString("DELETED\r\n")
+			} else {
+				rw.WriteString("NOT_FOUND\r\n")
+			}
+		default:
+			rw.WriteString("ERROR\r\n")
+		}
+
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func TestMemcachedStore(t *testing.T) {
+	server := newFakeMemcached(t, defaultMemcachedMaxItemSize)
+	defer server.close()
+
+	store, err := NewMemcachedStore([]string{server.addr()})
+	require.NoError(t, err)
+	defer store.Close()
+
+	expectVal := "123"
+	require.Nil(t, store.Set("test", expectVal, 1*time.Second))
+
+	value := ""
+	assert.Nil(t, store.Get("test", &value))
+	assert.Equal(t, expectVal, value)
+
+	require.Nil(t, store.Delete("test"))
+	assert.Equal(t, ErrCacheMiss, store.Get("test", &value))
+	assert.Nil(t, store.Delete("test"))
+
+	require.Nil(t, store.Set("expire", expectVal, 1*time.Second))
+	time.Sleep(1100 * time.Millisecond)
+	assert.Equal(t, ErrCacheMiss, store.Get("expire", &value))
+
+	// all the commands above share one pooled connection
+	assert.Equal(t, 1, server.connCount())
+}
+
+func TestMemcachedStoreItemTooLarge(t *testing.T) {
+	server := newFakeMemcached(t, 64)
+	defer server.close()
+
+	store, err := NewMemcachedStore([]string{server.addr()})
+	require.NoError(t, err)
+	defer store.Close()
+
+	// rejected by the server
+	assert.ErrorIs(t, store.Set("large", strings.Repeat("a", 128), time.Minute), ErrItemTooLarge)
+
+	// rejected by the client, without contacting the server
+	limited, err := NewMemcachedStore([]string{server.addr()}, WithMemcachedMaxItemSize(64))
+	require.NoError(t, err)
+	defer limited.Close()
+	assert.ErrorIs(t, limited.Set("large", strings.Repeat("a", 128), time.Minute), ErrItemTooLarge)
+
+	// the connection is still usable after the server rejection
+	require.Nil(t, store.Set("small", "a", time.Minute))
+	value := ""
+	assert.Nil(t, store.Get("small", &value))
+	assert.Equal(t, "a", value)
+}
+
+func TestMemcachedStoreMultiServers(t *testing.T) {
+	server1 := newFakeMemcached(t, defaultMemcachedMaxItemSize)
+	defer server1.close()
+	server2 := newFakeMemcached(t, defaultMemcachedMaxItemSize)
+	defer server2.close()
+
+	store, err := NewMemcachedStore([]string{server1.addr(), server2.addr()})
+	require.NoError(t, err)
+	defer store.Close()
+
+	for i := 0; i < 100; i++ {
+		require.Nil(t, store.Set(fmt.Sprintf("key_%d", i), i, time.Minute))
+	}
+
+	assert.NotEmpty(t, server1.keys())
+	assert.NotEmpty(t, server2.keys())
+	assert.Len(t, append(server1.keys(), server2.keys()...), 100)
+
+	for i := 0; i < 100; i++ {
+		value := 0
+		assert.Nil(t, store.Get(fmt.Sprintf("key_%d", i), &value))
+		assert.Equal(t, i, value)
+	}
+}
+
+func TestMemcachedKey(t *testing.T) {
+	assert.Equal(t, "/cache?uid=1", memcachedKey("/cache?uid=1"))
+
+	longKey := "/" + strings.Repeat("a", memcachedMaxKeyLength)
+	assert.True(t, strings.HasPrefix(memcachedKey(longKey), "sha1:"))
+	assert.True(t, strings.HasPrefix(memcachedKey("with space"), "sha1:"))
+	assert.NotEqual(t, memcachedKey("with space"), memcachedKey("with  space"))
+}
+
+func TestMemcachedExpiration(t *testing.T) {
+	assert.Equal(t, int64(0), memcachedExpiration(0))
+	assert.Equal(t, int64(0), memcachedExpiration(NoExpiration))
+	assert.Equal(t, int64(1), memcachedExpiration(100*time.Millisecond))
+	assert.Equal(t, int64(60), memcachedExpiration(time.Minute))
+	assert.True(t, memcachedExpiration(60*24*time.Hour) > time.Now().Unix())
+}
@@ -3,6 +3,7 @@ package persist
 import (
 	"errors"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/jellydator/ttlcache/v2"
@@ -11,6 +12,24 @@ import (
 // MemoryStore local memory cache store
 type MemoryStore struct {
 	Cache *ttlcache.Cache
+
+	defaultExpiration time.Duration
+
+	// expireAt records the deadline of every key, which ttlcache doesn't expose, for snapshots
+	mu       sync.Mutex
+	expireAt map[string]time.Time
+
+	lockMu sync.Mutex
+	locks  map[string]memoryLock
+	tokens int64
+
+	evictedMu    sync.RWMutex
+	evictedFuncs []func(key string)
+}
+
+type memoryLock struct {
+	token    int64
+	expireAt time.Time
 }
 
 // NewMemoryStore allocate a local memory store with default expiration
@@ -21,19 +40,63 @@ func NewMemoryStore(defaultExpiration time.Duration) *MemoryStore {
 	// disable SkipTTLExtensionOnHit default
 	cacheStore.SkipTTLExtensionOnHit(true)
 
-	return &MemoryStore{
-		Cache: cacheStore,
+	store := &MemoryStore{
+		Cache:             cacheStore,
+		defaultExpiration: defaultExpiration,
+		expireAt:          map[string]time.Time{},
 	}
+
+	cacheStore.SetExpirationReasonCallback(func(key string, reason ttlcache.EvictionReason, _ interface{}) {
+		store.forgetExpireAt(key)
+		if reason == ttlcache.Expired || reason == ttlcache.EvictedSize {
+			store.notifyEvicted(key)
+		}
+	})
+
+	return store
 }
 
 // Set put key value pair to memory store, and expire after expireDuration
 func (c *MemoryStore) Set(key string, value interface{}, expireDuration time.Duration) error {
-	return c.Cache.SetWithTTL(key, value, expireDuration)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if expireDuration < 0 {
+		// ttlcache applies its default ttl to zero only, a negative ttl never expires.
+		// It keeps the deadline of an existing item though, which then stays at the top of its expiration queue
+		// without ever expiring, and blocks the expiration of the other items, so the item is replaced.
+		expireDuration = NoExpiration
+		_ = c.Cache.Remove(key)
+	}
+	if err := c.Cache.SetWithTTL(key, value, expireDuration); err != nil {
+		return err
+	}
+
+	if expireDuration == 0 {
+		expireDuration = c.defaultExpiration
+	}
+
+	if c.expireAt == nil {
+		c.expireAt = map[string]time.Time{}
+	}
+	if expireDuration > 0 {
+		c.expireAt[key] = time.Now().Add(expireDuration)
+	} else {
+		delete(c.expireAt, key)
+	}
+	return nil
 }
 
 // Delete remove key in memory store, do nothing if key doesn't exist
 func (c *MemoryStore) Delete(key string) error {
-	return c.Cache.Remove(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.expireAt, key)
+	if err := c.Cache.Remove(key); err != nil && !errors.Is(err, ttlcache.ErrNotFound) {
+		return err
+	}
+	return nil
 }
 
 // Get key in memory store, if key doesn't exist, return ErrCacheMiss
@@ -47,3 +110,79 @@ func (c *MemoryStore) Get(key string, value interface{}) error {
 	v.Elem().Set(reflect.ValueOf(val))
 	return nil
 }
+
+// forgetExpireAt is called after ttlcache expired, evicted or removed the key, whatever the reason.
+// The callback runs asynchronously, so the deadline is kept if the key has been set again meanwhile.
+func (c *MemoryStore) forgetExpireAt(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.expireAt[key]; !ok {
+		return
+	}
+	if _, err := c.Cache.Get(key); errors.Is(err, ttlcache.ErrNotFound) {
+		delete(c.expireAt, key)
+	}
+}
+
+// OnEvicted registers fn to be called with the key of every expired or evicted entry
+func (c *MemoryStore) OnEvicted(fn func(key string)) {
+	c.evictedMu.Lock()
+	defer c.evictedMu.Unlock()
+
+	c.evictedFuncs = append(c.evictedFuncs, fn)
+}
+
+func (c *MemoryStore) notifyEvicted(key string) {
+	c.evictedMu.RLock()
+	defer c.evictedMu.RUnlock()
+
+	for _, fn := range c.evictedFuncs {
+		fn(key)
+	}
+}
+
+// remainingTTL returns the remaining time to live of key, zero means never expire
+func (c *MemoryStore) remainingTTL(key string, now time.Time) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expireAt, ok := c.expireAt[key]
+	if !ok {
+		return 0, true
+	}
+
+	ttl := expireAt.Sub(now)
+	return ttl, ttl > 0
+}
+
+// TryLock acquires an in-process lock of key, it's only useful when all the replicas share this store
+func (c *MemoryStore) TryLock(key string, ttl time.Duration) (int64, bool, error) {
+	c.lockMu.Lock()
+	defer c.lockMu.Unlock()
+
+	c.tokens++
+	token := c.tokens
+
+	now := time.Now()
+	if lock, ok := c.locks[key]; ok && lock.expireAt.After(now) {
+		return token, false, nil
+	}
+
+	if c.locks == nil {
+		c.locks = map[string]memoryLock{}
+	}
+	c.locks[key] = memoryLock{token: token, expireAt: now.Add(ttl)}
+	return token, true, nil
+}
+
+// Unlock releases the lock of key if it's still held with token
+func (c *MemoryStore) Unlock(key string, token int64) error {
+	c.lockMu.Lock()
+	defer c.lockMu.Unlock()
+
+	if lock, ok := c.locks[key]; ok && lock.token == token {
+		delete(c.locks, key)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package persist
 
 import (
 	"github.com/stretchr/testify/require"
+	"sync"
 	"testing"
 	"time"
 
@@ -20,4 +21,62 @@ func TestMemoryStore(t *testing.T) {
 
 	time.Sleep(1 * time.Second)
 	assert.Equal(t, ErrCacheMiss, memoryStore.Get("test", &value))
+
+	// deleting a missing or expired key does nothing
+	assert.Nil(t, memoryStore.Delete("test"))
+	assert.Nil(t, memoryStore.Delete("missing"))
+}
+
+func TestMemoryStoreLock(t *testing.T) {
+	memoryStore := NewMemoryStore(1 * time.Minute)
+
+	token1, acquired, err := memoryStore.TryLock("lock", 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	_, acquired, err = memoryStore.TryLock("lock", 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	// the lock expired, a newer holder gets another token
+	time.Sleep(100 * time.Millisecond)
+	token2, acquired, err := memoryStore.TryLock("lock", 1*time.Minute)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	assert.NotEqual(t, token1, token2)
+
+	// the stale holder can't release the lock of the newer holder
+	require.NoError(t, memoryStore.Unlock("lock", token1))
+	_, acquired, _ = memoryStore.TryLock("lock", 1*time.Minute)
+	assert.False(t, acquired)
+
+	require.NoError(t, memoryStore.Unlock("lock", token2))
+	_, acquired, _ = memoryStore.TryLock("lock", 1*time.Minute)
+	assert.True(t, acquired)
+}
+
+func TestMemoryStoreNoExpiration(t *testing.T) {
+	memoryStore := NewMemoryStore(1 * time.Minute)
+
+	var evicted []string
+	var mu sync.Mutex
+	memoryStore.OnEvicted(func(key string) {
+		mu.Lock()
+		defer mu.Unlock()
+		evicted = append(evicted, key)
+	})
+
+	// the item set again without expiration must not keep its previous deadline
+	require.Nil(t, memoryStore.Set("forever", "value", 10*time.Millisecond))
+	require.Nil(t, memoryStore.Set("forever", "value", NoExpiration))
+	require.Nil(t, memoryStore.Set("expire", "value", 100*time.Millisecond))
+	time.Sleep(400 * time.Millisecond)
+
+	value := ""
+	assert.Nil(t, memoryStore.Get("forever", &value))
+	assert.Equal(t, ErrCacheMiss, memoryStore.Get("expire", &value))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"expire"}, evicted)
 }
@@ -2,6 +2,8 @@ package persist
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"time"
 
@@ -28,7 +30,16 @@ func (store *RedisStore) Set(key string, value interface{}, expire time.Duration
 	}
 
 	ctx := context.TODO()
-	return store.RedisClient.Set(ctx, key, payload, expire).Err()
+	return store.RedisClient.Set(ctx, key, payload, redisExpiration(expire)).Err()
+}
+
+// redisExpiration convert the duration to the expiration of go-redis, a negative one means KEEPTTL to go-redis
+// while zero sets no expiration
+func redisExpiration(expire time.Duration) time.Duration {
+	if expire < 0 {
+		return 0
+	}
+	return expire
 }
 
 // Delete remove key in redis, do nothing if key doesn't exist
@@ -51,3 +62,40 @@ func (store *RedisStore) Get(key string, value interface{}) error {
 	}
 	return Deserialize(payload, value)
 }
+
+// redisUnlockScript deletes the lock only if it's still held by the token
+var redisUnlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// TryLock acquires the lock by SET NX PX with a random token, so that only the holder can unlock it
+func (store *RedisStore) TryLock(key string, ttl time.Duration) (int64, bool, error) {
+	token, err := randomLockToken()
+	if err != nil {
+		return 0, false, err
+	}
+
+	ctx := context.TODO()
+	acquired, err := store.RedisClient.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	return token, acquired, nil
+}
+
+// Unlock releases the lock if it's still held with token
+func (store *RedisStore) Unlock(key string, token int64) error {
+	ctx := context.TODO()
+	return redisUnlockScript.Run(ctx, store.RedisClient, []string{key}, token).Err()
+}
+
+func randomLockToken() (int64, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b[:]) >> 1), nil
+}
@@ -0,0 +1,14 @@
+package persist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisExpiration(t *testing.T) {
+	assert.Equal(t, time.Duration(0), redisExpiration(0))
+	assert.Equal(t, time.Duration(0), redisExpiration(NoExpiration))
+	assert.Equal(t, time.Minute, redisExpiration(time.Minute))
+}
@@ -0,0 +1,148 @@
+package persist
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrInvalidSnapshot represent the snapshot stream is not written by MemoryStore.Snapshot
+var ErrInvalidSnapshot = errors.New("persist invalid memory snapshot")
+
+const memorySnapshotMagic = "GCMS1\n"
+
+// memorySnapshotEntry is one record of the snapshot stream.
+// The concrete type of Value must be registered by gob.Register, *cache.ResponseCache already is.
+type memorySnapshotEntry struct {
+	Key string
+	TTL time.Duration
+	// SavedAt is the unix nano when the entry is written, TTL is relative to it
+	SavedAt int64
+	Value   interface{}
+}
+
+// Snapshot writes all the live entries with their remaining TTLs to w.
+// Entries are streamed one by one, the snapshot is never built in memory as a whole.
+func (c *MemoryStore) Snapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(memorySnapshotMagic); err != nil {
+		return err
+	}
+
+	encoder := gob.NewEncoder(bw)
+	for _, key := range c.Cache.GetKeys() {
+		value, err := c.Cache.Get(key)
+		if err != nil {
+			// expired or removed after GetKeys
+			continue
+		}
+
+		now := time.Now()
+		ttl, alive := c.remainingTTL(key, now)
+		if !alive {
+			continue
+		}
+
+		entry := memorySnapshotEntry{
+			Key:     key,
+			TTL:     ttl,
+			SavedAt: now.UnixNano(),
+			Value:   value,
+		}
+		if err := encoder.Encode(&entry); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Restore loads the entries written by Snapshot, entries which have expired since are skipped.
+// The time passed between Snapshot and Restore is deducted from the remaining TTLs.
+func (c *MemoryStore) Restore(r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(memorySnapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != memorySnapshotMagic {
+		return ErrInvalidSnapshot
+	}
+
+	decoder := gob.NewDecoder(br)
+	for {
+		var entry memorySnapshotEntry
+		err := decoder.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		ttl := entry.TTL
+		if ttl > 0 {
+			ttl -= time.Duration(time.Now().UnixNano() - entry.SavedAt)
+			if ttl <= 0 {
+				continue
+			}
+		}
+
+		if err := c.restoreEntry(entry.Key, entry.Value, ttl); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *MemoryStore) restoreEntry(key string, value interface{}, ttl time.Duration) error {
+	if ttl > 0 {
+		return c.Set(key, value, ttl)
+	}
+
+	// never expire, the default expiration of the store must not apply
+	return c.Set(key, value, NoExpiration)
+}
+
+// SaveSnapshot writes the snapshot to the file at path.
+// The file is replaced atomically, a crash during saving keeps the previous snapshot intact.
+func (c *MemoryStore) SaveSnapshot(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := f.Name()
+	if err := c.Snapshot(f); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// LoadSnapshot restores the snapshot from the file at path, it does nothing if the file doesn't exist
+func (c *MemoryStore) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return c.Restore(f)
+}
@@ -0,0 +1,133 @@
+package persist
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	gob.Register(&testStruct{})
+}
+
+func TestMemoryStoreSnapshot(t *testing.T) {
+	src := NewMemoryStore(1 * time.Minute)
+	require.Nil(t, src.Set("short", "short_value", 1*time.Second))
+	require.Nil(t, src.Set("long", &testStruct{A: 1, B: "2"}, 1*time.Hour))
+	require.Nil(t, src.Set("default", "default_value", 0))
+
+	var buf bytes.Buffer
+	require.NoError(t, src.Snapshot(&buf))
+
+	// let the short entry expire between snapshot and restore
+	time.Sleep(1 * time.Second)
+
+	dest := NewMemoryStore(1 * time.Minute)
+	require.NoError(t, dest.Restore(&buf))
+
+	var str string
+	assert.Equal(t, ErrCacheMiss, dest.Get("short", &str))
+
+	assert.Nil(t, dest.Get("default", &str))
+	assert.Equal(t, "default_value", str)
+
+	var val *testStruct
+	assert.Nil(t, dest.Get("long", &val))
+	assert.Equal(t, 1, val.A)
+	assert.Equal(t, "2", val.B)
+
+	ttl, alive := dest.remainingTTL("long", time.Now())
+	assert.True(t, alive)
+	assert.True(t, ttl > 59*time.Minute && ttl < time.Hour)
+}
+
+func TestMemoryStoreForgetExpireAt(t *testing.T) {
+	store := NewMemoryStore(1 * time.Minute)
+	store.Cache.SetCacheSizeLimit(1)
+
+	expireAtLen := func() int {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.expireAt)
+	}
+
+	// evicted for size and removed before their deadline
+	require.Nil(t, store.Set("evicted", "value", time.Hour))
+	require.Nil(t, store.Set("removed", "value", time.Hour))
+	require.Nil(t, store.Cache.Remove("removed"))
+	assert.Eventually(t, func() bool { return expireAtLen() == 0 }, time.Second, 10*time.Millisecond)
+
+	// set again before the callback runs
+	require.Nil(t, store.Set("again", "value", time.Hour))
+	require.Nil(t, store.Cache.Remove("again"))
+	require.Nil(t, store.Set("again", "value", time.Hour))
+	time.Sleep(50 * time.Millisecond)
+
+	ttl, alive := store.remainingTTL("again", time.Now())
+	assert.True(t, alive)
+	assert.True(t, ttl > 59*time.Minute)
+}
+
+func TestMemoryStoreRestoreInvalid(t *testing.T) {
+	store := NewMemoryStore(1 * time.Minute)
+	assert.Equal(t, ErrInvalidSnapshot, store.Restore(bytes.NewBufferString("not a snapshot")))
+}
+
+func TestMemoryStoreSaveLoadSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gin-cache-snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "memory.snapshot")
+
+	// first boot, no snapshot yet
+	dest := NewMemoryStore(1 * time.Minute)
+	require.NoError(t, dest.LoadSnapshot(path))
+
+	src := NewMemoryStore(1 * time.Minute)
+	require.Nil(t, src.Set("test", "123", 1*time.Minute))
+	require.NoError(t, src.SaveSnapshot(path))
+
+	require.NoError(t, dest.LoadSnapshot(path))
+
+	value := ""
+	assert.Nil(t, dest.Get("test", &value))
+	assert.Equal(t, "123", value)
+}
+
+func TestMemoryStoreRestoreNoExpiration(t *testing.T) {
+	src := NewMemoryStore(1 * time.Minute)
+	require.Nil(t, src.Set("forever", "value", NoExpiration))
+
+	var buf bytes.Buffer
+	require.NoError(t, src.Snapshot(&buf))
+
+	dest := NewMemoryStore(1 * time.Minute)
+	evicted := make(chan string, 1)
+	dest.OnEvicted(func(key string) {
+		evicted <- key
+	})
+
+	// the restored entry replaces the existing one, whose deadline must not block the expiration of the others
+	require.Nil(t, dest.Set("forever", "old_value", 100*time.Millisecond))
+	require.NoError(t, dest.Restore(&buf))
+	require.Nil(t, dest.Set("expire", "value", 200*time.Millisecond))
+
+	select {
+	case key := <-evicted:
+		assert.Equal(t, "expire", key)
+	case <-time.After(time.Second):
+		t.Fatal("the entry should be evicted")
+	}
+
+	var str string
+	assert.Nil(t, dest.Get("forever", &str))
+	assert.Equal(t, "value", str)
+}
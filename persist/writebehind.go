@@ -0,0 +1,305 @@
+package persist
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrWriteQueueFull represent the write is dropped because the write-behind queue is full
+	ErrWriteQueueFull = errors.New("persist write-behind queue full")
+
+	// ErrStoreClosed represent the store has been closed
+	ErrStoreClosed = errors.New("persist store closed")
+)
+
+const (
+	defaultWriteBehindWorkers   = 4
+	defaultWriteBehindQueueSize = 1024
+)
+
+// WriteBehindOption represents the optional function of WriteBehindStore
+type WriteBehindOption func(store *WriteBehindStore)
+
+// WithWriteBehindWorkers set the number of background workers writing to the underlying store
+func WithWriteBehindWorkers(workers int) WriteBehindOption {
+	return func(store *WriteBehindStore) {
+		if workers > 0 {
+			store.workers = workers
+		}
+	}
+}
+
+// WithWriteBehindQueueSize set the max number of keys waiting to be written, Set beyond it is dropped,
+// Delete beyond it waits for room
+func WithWriteBehindQueueSize(queueSize int) WriteBehindOption {
+	return func(store *WriteBehindStore) {
+		if queueSize > 0 {
+			store.queueSize = queueSize
+		}
+	}
+}
+
+// WithWriteBehindErrorHandler set the handler of the errors returned by the underlying store in background
+func WithWriteBehindErrorHandler(handler func(key string, err error)) WriteBehindOption {
+	return func(store *WriteBehindStore) {
+		if handler != nil {
+			store.errorHandler = handler
+		}
+	}
+}
+
+// WriteBehindStore wraps a CacheStore, Set and Delete return immediately and are applied by background workers.
+// Pending writes of the same key are coalesced, only the latest one is applied.
+// When the queue is full, Set is dropped and returns ErrWriteQueueFull, while Delete waits for room.
+// The expiration counts from Set, not from the write. Get sees the pending writes. Call Close for graceful shutdown, so that no pending write is lost.
+type WriteBehindStore struct {
+	store        CacheStore
+	workers      int
+	queueSize    int
+	errorHandler func(key string, err error)
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// pending is the latest write of every key not yet started, queue keeps their order
+	pending map[string]*writeBehindOp
+	queue   []string
+	// inFlight is the writes being applied, at most one for each key to keep the order
+	inFlight map[string]*writeBehindOp
+	// enqueued numbers the writes for Flush
+	enqueued uint64
+	closed   bool
+
+	dropped int64
+	wg      sync.WaitGroup
+}
+
+type writeBehindOp struct {
+	value interface{}
+	// expireAt is the deadline computed at Set, zero if expire isn't positive
+	expireAt time.Time
+	// expire is passed as is if it isn't positive, e.g. NoExpiration
+	expire time.Duration
+	delete bool
+	// seq is the number of the write, a coalesced write keeps the one of the write it replaces
+	seq uint64
+}
+
+// expired returns whether the deadline of the write has passed before it's applied
+func (op *writeBehindOp) expired(now time.Time) bool {
+	return !op.expireAt.IsZero() && !op.expireAt.After(now)
+}
+
+// NewWriteBehindStore wraps store and starts the background workers
+func NewWriteBehindStore(store CacheStore, opts ...WriteBehindOption) *WriteBehindStore {
+	wb := &WriteBehindStore{
+		store:        store,
+		workers:      defaultWriteBehindWorkers,
+		queueSize:    defaultWriteBehindQueueSize,
+		errorHandler: func(string, error) {},
+		pending:      map[string]*writeBehindOp{},
+		inFlight:     map[string]*writeBehindOp{},
+	}
+	wb.cond = sync.NewCond(&wb.mu)
+
+	for _, opt := range opts {
+		opt(wb)
+	}
+
+	wb.wg.Add(wb.workers)
+	for i := 0; i < wb.workers; i++ {
+		go wb.work()
+	}
+
+	return wb
+}
+
+// Set enqueues the write, the value must not be modified afterwards
+func (wb *WriteBehindStore) Set(key string, value interface{}, expire time.Duration) error {
+	op := &writeBehindOp{value: value, expire: expire}
+	if expire > 0 {
+		op.expireAt = time.Now().Add(expire)
+	}
+	return wb.enqueue(key, op)
+}
+
+// Delete enqueues the removal of key, it's never dropped, it blocks while the queue is full
+func (wb *WriteBehindStore) Delete(key string) error {
+	return wb.enqueue(key, &writeBehindOp{delete: true})
+}
+
+// Get retrieves the pending write of key first, then the underlying store
+func (wb *WriteBehindStore) Get(key string, value interface{}) error {
+	wb.mu.Lock()
+	op, ok := wb.pending[key]
+	if !ok {
+		op, ok = wb.inFlight[key]
+	}
+	wb.mu.Unlock()
+
+	if ok {
+		if op.delete || op.expired(time.Now()) {
+			return ErrCacheMiss
+		}
+
+		v := reflect.ValueOf(value)
+		opValue := reflect.ValueOf(op.value)
+		if v.Kind() == reflect.Ptr && opValue.IsValid() && opValue.Type().AssignableTo(v.Elem().Type()) {
+			v.Elem().Set(opValue)
+			return nil
+		}
+	}
+
+	return wb.store.Get(key, value)
+}
+
+// Dropped returns the total number of writes dropped because the queue is full
+func (wb *WriteBehindStore) Dropped() int64 {
+	return atomic.LoadInt64(&wb.dropped)
+}
+
+// Flush blocks until all the writes enqueued before have been applied, the writes enqueued meanwhile
+// aren't waited for
+func (wb *WriteBehindStore) Flush() {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	target := wb.enqueued
+	for {
+		oldest, ok := wb.oldestLocked()
+		if !ok || oldest > target {
+			return
+		}
+		wb.cond.Wait()
+	}
+}
+
+// oldestLocked returns the smallest seq of the writes not applied yet, the queue is ordered by seq
+func (wb *WriteBehindStore) oldestLocked() (uint64, bool) {
+	var oldest uint64
+	found := false
+	if len(wb.queue) > 0 {
+		oldest, found = wb.pending[wb.queue[0]].seq, true
+	}
+	for _, op := range wb.inFlight {
+		if !found || op.seq < oldest {
+			oldest, found = op.seq, true
+		}
+	}
+	return oldest, found
+}
+
+// Close applies all the pending writes and stops the workers, following writes return ErrStoreClosed
+func (wb *WriteBehindStore) Close() error {
+	wb.mu.Lock()
+	wb.closed = true
+	wb.cond.Broadcast()
+	wb.mu.Unlock()
+
+	wb.wg.Wait()
+	return nil
+}
+
+func (wb *WriteBehindStore) enqueue(key string, op *writeBehindOp) error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	if wb.closed {
+		return ErrStoreClosed
+	}
+
+	for {
+		if pending, ok := wb.pending[key]; ok {
+			// coalesce with the pending write of the same key
+			op.seq = pending.seq
+			wb.pending[key] = op
+			return nil
+		}
+
+		if len(wb.pending) < wb.queueSize {
+			break
+		}
+
+		if !op.delete {
+			atomic.AddInt64(&wb.dropped, 1)
+			return ErrWriteQueueFull
+		}
+
+		// dropping a delete would leave a stale entry, wait for a worker to make room instead
+		wb.cond.Wait()
+		if wb.closed {
+			return ErrStoreClosed
+		}
+	}
+
+	wb.enqueued++
+	op.seq = wb.enqueued
+	wb.pending[key] = op
+	wb.queue = append(wb.queue, key)
+	wb.cond.Broadcast()
+	return nil
+}
+
+// next pops the first pending key not being written, it blocks until one is ready or the store is closed and drained
+func (wb *WriteBehindStore) next() (string, *writeBehindOp, bool) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	for {
+		for i, key := range wb.queue {
+			if _, busy := wb.inFlight[key]; busy {
+				continue
+			}
+
+			op := wb.pending[key]
+			delete(wb.pending, key)
+			wb.queue = append(wb.queue[:i], wb.queue[i+1:]...)
+			wb.inFlight[key] = op
+			return key, op, true
+		}
+
+		if wb.closed && len(wb.queue) == 0 {
+			return "", nil, false
+		}
+		wb.cond.Wait()
+	}
+}
+
+func (wb *WriteBehindStore) work() {
+	defer wb.wg.Done()
+
+	for {
+		key, op, ok := wb.next()
+		if !ok {
+			return
+		}
+
+		var err error
+		switch {
+		case op.delete:
+			err = wb.store.Delete(key)
+		case op.expireAt.IsZero():
+			err = wb.store.Set(key, op.value, op.expire)
+		default:
+			// only the remaining time to live, the time spent in the queue doesn't extend it
+			remaining := time.Until(op.expireAt)
+			if remaining > 0 {
+				err = wb.store.Set(key, op.value, remaining)
+			} else {
+				// expired while queued, drop the older value as the write would have replaced it
+				err = wb.store.Delete(key)
+			}
+		}
+		if err != nil {
+			wb.errorHandler(key, err)
+		}
+
+		wb.mu.Lock()
+		delete(wb.inFlight, key)
+		wb.cond.Broadcast()
+		wb.mu.Unlock()
+	}
+}
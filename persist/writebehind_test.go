@@ -0,0 +1,210 @@
+package persist
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowStore delays every Set, and counts the calls
+type slowStore struct {
+	*MemoryStore
+
+	delay    time.Duration
+	setCount int32
+
+	blocked chan struct{}
+}
+
+func (s *slowStore) Set(key string, value interface{}, expire time.Duration) error {
+	atomic.AddInt32(&s.setCount, 1)
+	if s.blocked != nil {
+		<-s.blocked
+	}
+	time.Sleep(s.delay)
+	return s.MemoryStore.Set(key, value, expire)
+}
+
+func TestWriteBehindStore(t *testing.T) {
+	underlying := &slowStore{MemoryStore: NewMemoryStore(1 * time.Minute), delay: 100 * time.Millisecond}
+	store := NewWriteBehindStore(underlying)
+
+	start := time.Now()
+	require.Nil(t, store.Set("test", "123", 1*time.Minute))
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+
+	// the pending write is visible
+	value := ""
+	assert.Nil(t, store.Get("test", &value))
+	assert.Equal(t, "123", value)
+
+	store.Flush()
+	value = ""
+	assert.Nil(t, underlying.Get("test", &value))
+	assert.Equal(t, "123", value)
+
+	require.Nil(t, store.Delete("test"))
+	assert.Equal(t, ErrCacheMiss, store.Get("test", &value))
+	store.Flush()
+	assert.Equal(t, ErrCacheMiss, underlying.Get("test", &value))
+
+	require.Nil(t, store.Close())
+	assert.Equal(t, ErrStoreClosed, store.Set("test", "123", 1*time.Minute))
+}
+
+func TestWriteBehindStoreCoalesce(t *testing.T) {
+	underlying := &slowStore{MemoryStore: NewMemoryStore(1 * time.Minute), blocked: make(chan struct{})}
+	store := NewWriteBehindStore(underlying, WithWriteBehindWorkers(1))
+
+	// the worker is blocked by the first write, the following ones are coalesced
+	require.Nil(t, store.Set("test", 0, 1*time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	for i := 1; i <= 10; i++ {
+		require.Nil(t, store.Set("test", i, 1*time.Minute))
+	}
+	close(underlying.blocked)
+
+	require.Nil(t, store.Close())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&underlying.setCount))
+
+	value := 0
+	assert.Nil(t, underlying.Get("test", &value))
+	assert.Equal(t, 10, value)
+}
+
+func TestWriteBehindStoreFlushWhileEnqueuing(t *testing.T) {
+	underlying := &slowStore{MemoryStore: NewMemoryStore(1 * time.Minute), delay: time.Millisecond}
+	store := NewWriteBehindStore(underlying, WithWriteBehindWorkers(2))
+	require.Nil(t, store.Set("before", "value", 1*time.Minute))
+
+	// the queue never drains while the writes keep coming
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = store.Set(fmt.Sprintf("key_%d", i), i, 1*time.Minute)
+				time.Sleep(100 * time.Microsecond)
+			}
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	flushed := make(chan struct{})
+	go func() {
+		store.Flush()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-time.After(time.Second):
+		t.Fatal("Flush should only wait for the writes enqueued before")
+	}
+
+	value := ""
+	assert.Nil(t, underlying.Get("before", &value))
+
+	close(stop)
+	<-done
+	require.Nil(t, store.Close())
+}
+
+func TestWriteBehindStoreQueueFull(t *testing.T) {
+	underlying := &slowStore{MemoryStore: NewMemoryStore(1 * time.Minute), blocked: make(chan struct{})}
+	store := NewWriteBehindStore(underlying, WithWriteBehindWorkers(1), WithWriteBehindQueueSize(2))
+
+	require.Nil(t, store.Set("key_0", 0, 1*time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	require.Nil(t, store.Set("key_1", 1, 1*time.Minute))
+	require.Nil(t, store.Set("key_2", 2, 1*time.Minute))
+	assert.Equal(t, ErrWriteQueueFull, store.Set("key_3", 3, 1*time.Minute))
+	assert.Equal(t, int64(1), store.Dropped())
+
+	// deletes are never dropped, they wait for room instead
+	deleted := make(chan error, 1)
+	go func() {
+		deleted <- store.Delete("key_4")
+	}()
+	select {
+	case <-deleted:
+		t.Fatal("delete should wait for room in the queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(underlying.blocked)
+	assert.Nil(t, <-deleted)
+	require.Nil(t, store.Close())
+}
+
+func TestWriteBehindStoreRemainingTTL(t *testing.T) {
+	underlying := &slowStore{MemoryStore: NewMemoryStore(1 * time.Minute), blocked: make(chan struct{})}
+	store := NewWriteBehindStore(underlying, WithWriteBehindWorkers(1))
+
+	// the worker is blocked by the first write, the following ones wait in the queue
+	require.Nil(t, store.Set("key_0", 0, 1*time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	require.Nil(t, store.Set("short", 1, 100*time.Millisecond))
+	require.Nil(t, store.Set("long", 2, 1*time.Second))
+	time.Sleep(200 * time.Millisecond)
+
+	value := 0
+	assert.Equal(t, ErrCacheMiss, store.Get("short", &value))
+
+	close(underlying.blocked)
+	store.Flush()
+	assert.Equal(t, ErrCacheMiss, underlying.Get("short", &value))
+
+	// the time spent in the queue doesn't extend the expiration
+	ttl, alive := underlying.remainingTTL("long", time.Now())
+	assert.True(t, alive)
+	assert.True(t, ttl < 900*time.Millisecond)
+
+	require.Nil(t, store.Close())
+}
+
+func TestWriteBehindStoreNoExpiration(t *testing.T) {
+	underlying := &slowStore{MemoryStore: NewMemoryStore(100 * time.Millisecond)}
+	store := NewWriteBehindStore(underlying)
+
+	require.Nil(t, store.Set("forever", "value", NoExpiration))
+	store.Flush()
+	time.Sleep(200 * time.Millisecond)
+
+	// NoExpiration is passed through, the default expiration of the underlying store doesn't apply
+	value := ""
+	assert.Nil(t, underlying.Get("forever", &value))
+
+	require.Nil(t, store.Close())
+}
+
+func TestWriteBehindStoreClose(t *testing.T) {
+	underlying := &slowStore{MemoryStore: NewMemoryStore(1 * time.Minute), delay: time.Millisecond}
+	store := NewWriteBehindStore(underlying)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(t, store.Set(fmt.Sprintf("key_%d", i), i, 1*time.Minute))
+		}(i)
+	}
+	wg.Wait()
+
+	// no write is lost on graceful shutdown
+	require.Nil(t, store.Close())
+	for i := 0; i < 100; i++ {
+		value := 0
+		assert.Nil(t, underlying.Get(fmt.Sprintf("key_%d", i), &value))
+		assert.Equal(t, i, value)
+	}
+}
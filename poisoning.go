@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultMinReflectionLength = 4
+
+var (
+	// ErrUnkeyedHeader the request carries an unkeyed header changing the response, it's not cached
+	ErrUnkeyedHeader = errors.New("gin-cache: request with unkeyed header not cached")
+
+	// ErrReflectedHeader the response reflects a request header value, it's neither stored nor shared
+	ErrReflectedHeader = errors.New("gin-cache: response reflecting request header not cached")
+)
+
+// PoisoningGuard describe the safeguards against the cache poisoning through the headers not in the key
+type PoisoningGuard struct {
+	// UnkeyedHeaders maps the request headers changing the response but not in the key to their default values.
+	// The requests carrying them with another value are not cached, an empty default means the header must be absent.
+	UnkeyedHeaders map[string]string
+
+	// ReflectedHeaders are the request headers whose values must not appear in the stored responses,
+	// neither in the body nor in the headers. The names of UnkeyedHeaders are checked as well, except their default values.
+	ReflectedHeaders []string
+
+	// MinReflectionLength ignores the shorter values to avoid false positives, default 4
+	MinReflectionLength int
+}
+
+// PoisoningHeaders the common request headers abused to poison the caches, to be used in PoisoningGuard
+func PoisoningHeaders() []string {
+	return []string{
+		"X-Forwarded-Host",
+		"X-Forwarded-Server",
+		"X-Forwarded-Scheme",
+		"X-Host",
+		"X-Original-Url",
+		"X-Rewrite-Url",
+		"X-Http-Method-Override",
+	}
+}
+
+// PoisoningReport describe a request or response refused by PoisoningGuard
+type PoisoningReport struct {
+	// Key is the cache key, empty for ErrUnkeyedHeader
+	Key string
+
+	Header string
+	Value  string
+
+	// Err is ErrUnkeyedHeader or ErrReflectedHeader
+	Err error
+}
+
+// OnPoisoningDetectedCallback define the callback when PoisoningGuard refuses a request or a response
+type OnPoisoningDetectedCallback func(c *gin.Context, report PoisoningReport)
+
+var defaultPoisoningDetectedCallback = func(c *gin.Context, report PoisoningReport) {}
+
+// WithPoisoningGuard enable the safeguards against the cache poisoning.
+// The requests carrying an unkeyed header are not cached at all, and the responses reflecting
+// a request header are neither stored nor shared with the singleflight followers.
+func WithPoisoningGuard(guard PoisoningGuard) Option {
+	return func(c *Config) {
+		if guard.MinReflectionLength <= 0 {
+			guard.MinReflectionLength = defaultMinReflectionLength
+		}
+
+		unkeyedHeaders := make(map[string]string, len(guard.UnkeyedHeaders))
+		reflectedHeaders := make([]string, 0, len(guard.ReflectedHeaders)+len(guard.UnkeyedHeaders))
+		for header, defaultValue := range guard.UnkeyedHeaders {
+			header = http.CanonicalHeaderKey(header)
+			unkeyedHeaders[header] = defaultValue
+			reflectedHeaders = append(reflectedHeaders, header)
+		}
+		for _, header := range guard.ReflectedHeaders {
+			header = http.CanonicalHeaderKey(header)
+			if _, ok := unkeyedHeaders[header]; !ok {
+				reflectedHeaders = append(reflectedHeaders, header)
+			}
+		}
+
+		guard.UnkeyedHeaders = unkeyedHeaders
+		guard.ReflectedHeaders = reflectedHeaders
+		c.poisoningGuard = &guard
+	}
+}
+
+// WithOnPoisoningDetected will be called when PoisoningGuard refuses a request or a response
+func WithOnPoisoningDetected(cb OnPoisoningDetectedCallback) Option {
+	return func(c *Config) {
+		if cb != nil {
+			c.poisoningDetectedCallback = cb
+		}
+	}
+}
+
+// checkUnkeyedHeaders returns ErrUnkeyedHeader if the request carries an unkeyed header with another value
+func checkUnkeyedHeaders(c *gin.Context, cfg *Config) error {
+	if cfg.poisoningGuard == nil {
+		return nil
+	}
+
+	for header, defaultValue := range cfg.poisoningGuard.UnkeyedHeaders {
+		values, ok := c.Request.Header[header]
+		if !ok {
+			continue
+		}
+
+		for _, value := range values {
+			if value != defaultValue {
+				cfg.poisoningDetectedCallback(c, PoisoningReport{Header: header, Value: value, Err: ErrUnkeyedHeader})
+				return ErrUnkeyedHeader
+			}
+		}
+	}
+	return nil
+}
+
+// checkReflectedHeaders returns ErrReflectedHeader if respCache contains the value of a request header
+func checkReflectedHeaders(c *gin.Context, cfg *Config, cacheKey string, respCache *ResponseCache) error {
+	guard := cfg.poisoningGuard
+	if guard == nil {
+		return nil
+	}
+
+	for _, header := range guard.ReflectedHeaders {
+		defaultValue, unkeyed := guard.UnkeyedHeaders[header]
+		for _, value := range c.Request.Header[header] {
+			if len(value) < guard.MinReflectionLength {
+				continue
+			}
+			if unkeyed && value == defaultValue {
+				// the default value is the same for all the requests, reflecting it is harmless
+				continue
+			}
+
+			if bytes.Contains(respCache.Data, []byte(value)) || headerContains(respCache.Header, value) {
+				cfg.poisoningDetectedCallback(c, PoisoningReport{
+					Key:    cacheKey,
+					Header: header,
+					Value:  value,
+					Err:    ErrReflectedHeader,
+				})
+				return ErrReflectedHeader
+			}
+		}
+	}
+	return nil
+}
+
+func headerContains(header http.Header, s string) bool {
+	for _, values := range header {
+		for _, value := range values {
+			if strings.Contains(value, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
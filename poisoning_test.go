@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoisoningGuardReflectedHeader(t *testing.T) {
+	calls := 0
+	var reports []PoisoningReport
+	engine := gin.New()
+	engine.GET("/page",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second,
+			WithPoisoningGuard(PoisoningGuard{ReflectedHeaders: PoisoningHeaders()}),
+			WithOnPoisoningDetected(func(c *gin.Context, report PoisoningReport) {
+				reports = append(reports, report)
+			}),
+		),
+		func(c *gin.Context) {
+			calls++
+			host := c.GetHeader("X-Forwarded-Host")
+			if host == "" {
+				host = "example.com"
+			}
+			c.String(http.StatusOK, `<script src="https://%s/app.js"></script>`, host)
+		},
+	)
+
+	serve := func(host string) string {
+		req := httptest.NewRequest(http.MethodGet, "/page", nil)
+		if host != "" {
+			req.Header.Set("X-Forwarded-Host", host)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	assert.Contains(t, serve("evil.example"), "evil.example")
+
+	// the poisoned response isn't stored
+	assert.Contains(t, serve(""), "https://example.com/")
+	assert.Contains(t, serve(""), "https://example.com/")
+	assert.Equal(t, 2, calls)
+
+	require.Len(t, reports, 1)
+	assert.Equal(t, "/page", reports[0].Key)
+	assert.Equal(t, "X-Forwarded-Host", reports[0].Header)
+	assert.Equal(t, "evil.example", reports[0].Value)
+	assert.Equal(t, ErrReflectedHeader, reports[0].Err)
+}
+
+func TestPoisoningGuardUnkeyedHeader(t *testing.T) {
+	calls := 0
+	var reports []PoisoningReport
+	engine := gin.New()
+	engine.GET("/page",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second,
+			WithPoisoningGuard(PoisoningGuard{
+				UnkeyedHeaders: map[string]string{"x-original-url": ""},
+			}),
+			WithOnPoisoningDetected(func(c *gin.Context, report PoisoningReport) {
+				reports = append(reports, report)
+			}),
+		),
+		func(c *gin.Context) {
+			calls++
+			c.String(http.StatusOK, "page")
+		},
+	)
+
+	serve := func(originalURL string) {
+		req := httptest.NewRequest(http.MethodGet, "/page", nil)
+		if originalURL != "" {
+			req.Header.Set("X-Original-URL", originalURL)
+		}
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve("")
+	serve("/admin")
+	serve("")
+	assert.Equal(t, 2, calls)
+
+	require.Len(t, reports, 1)
+	assert.Equal(t, "X-Original-Url", reports[0].Header)
+	assert.Equal(t, ErrUnkeyedHeader, reports[0].Err)
+}
+
+func TestPoisoningGuardUnkeyedHeaderDefault(t *testing.T) {
+	calls := 0
+	var reports []PoisoningReport
+	engine := gin.New()
+	engine.GET("/page",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second,
+			WithPoisoningGuard(PoisoningGuard{
+				UnkeyedHeaders: map[string]string{"X-Forwarded-Host": "example.com"},
+			}),
+			WithOnPoisoningDetected(func(c *gin.Context, report PoisoningReport) {
+				reports = append(reports, report)
+			}),
+		),
+		func(c *gin.Context) {
+			calls++
+			c.String(http.StatusOK, "https://%s/page", c.GetHeader("X-Forwarded-Host"))
+		},
+	)
+
+	// the default value is reflected by every response, it doesn't prevent caching
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/page", nil)
+		req.Header.Set("X-Forwarded-Host", "example.com")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, "https://example.com/page", w.Body.String())
+	}
+	assert.Equal(t, 1, calls)
+	assert.Empty(t, reports)
+}
+
+func TestPoisoningGuardSingleFlight(t *testing.T) {
+	engine := gin.New()
+	engine.GET("/cache",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second,
+			WithPoisoningGuard(PoisoningGuard{ReflectedHeaders: PoisoningHeaders()}),
+		),
+		func(c *gin.Context) {
+			time.Sleep(200 * time.Millisecond)
+			c.String(http.StatusOK, "host=%s", c.GetHeader("X-Forwarded-Host"))
+		},
+	)
+
+	leaderReq := httptest.NewRequest(http.MethodGet, "/cache", nil)
+	leaderReq.Header.Set("X-Forwarded-Host", "evil.example")
+
+	leader, followers := mockSingleFlight(engine, leaderReq, 3)
+	assert.Equal(t, "host=evil.example", leader.Body.String())
+	for _, follower := range followers {
+		assert.Equal(t, "host=", follower.Body.String())
+	}
+}
@@ -0,0 +1,220 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrAuthorizationNotCacheable the request carries Authorization, it's only cached by WithPrivateCache,
+	// unless CacheabilityPolicy.Authorization is CacheabilityStore
+	ErrAuthorizationNotCacheable = errors.New("gin-cache: request with authorization not cached without private cache")
+
+	// ErrNoPrincipal the request carries Authorization, but its principal is unknown
+	ErrNoPrincipal = errors.New("gin-cache: no principal of the request with authorization")
+)
+
+// defaultPrincipalVersionTTL is how long the version of a purged principal is kept, see WithNamespaceVersionTTL
+const defaultPrincipalVersionTTL = 24 * time.Hour
+
+// PrincipalFunc returns the identity of the request, e.g. the user or the tenant, false if anonymous.
+// The principal must come from the authenticated data, so the cache middleware should be used after
+// the authentication middleware.
+type PrincipalFunc func(c *gin.Context) (string, bool)
+
+// PrincipalFromContext returns the value set by c.Set of key, e.g. the JWT subject set by the authentication middleware
+func PrincipalFromContext(key string) PrincipalFunc {
+	return func(c *gin.Context) (string, bool) {
+		value, ok := c.Get(key)
+		if !ok || value == nil {
+			return "", false
+		}
+
+		principal := fmt.Sprint(value)
+		return principal, principal != ""
+	}
+}
+
+// PrincipalFromHeader returns the request header name, e.g. the tenant header set by a trusted gateway
+func PrincipalFromHeader(name string) PrincipalFunc {
+	return func(c *gin.Context) (string, bool) {
+		principal := c.GetHeader(name)
+		return principal, principal != ""
+	}
+}
+
+// PrincipalFromCookie returns the cookie name, e.g. the session id
+func PrincipalFromCookie(name string) PrincipalFunc {
+	return func(c *gin.Context) (string, bool) {
+		principal, err := c.Cookie(name)
+		return principal, err == nil && principal != ""
+	}
+}
+
+// PrivateCacheOption represents the optional function of PrivateCache
+type PrivateCacheOption func(p *PrivateCache)
+
+// WithPrincipalQuota limits the entries per principal, the oldest entry of the principal is deleted beyond it
+func WithPrincipalQuota(maxEntries int) PrivateCacheOption {
+	return func(p *PrivateCache) {
+		if maxEntries > 0 {
+			p.maxEntries = maxEntries
+		}
+	}
+}
+
+// WithPrincipalNamespaceOptions set the options of the namespaces of the principals, e.g. the refresh interval.
+// Their versions are kept for 24h after Purge by default, use WithNamespaceVersionTTL if the entries live longer.
+func WithPrincipalNamespaceOptions(opts ...NamespaceOption) PrivateCacheOption {
+	return func(p *PrivateCache) {
+		p.namespaceOpts = append(p.namespaceOpts, opts...)
+	}
+}
+
+// PrivateCache scopes the cache keys to the principal of the request, so the authenticated endpoints can be cached.
+// It folds a version per principal into the keys, the versions are namespaces stored in the store shared by
+// the replicas, so that Purge invalidates the entries of a principal on all of them. Only the purged principals
+// have a version in the store, and the namespaces of the principals idle for their refresh interval are dropped
+// from memory. It also records the keys stored by this process for Keys and the quota, the entries
+// stored by other replicas are not counted.
+type PrivateCache struct {
+	store         persist.CacheStore
+	principal     PrincipalFunc
+	maxEntries    int
+	namespaceOpts []NamespaceOption
+
+	mu         sync.Mutex
+	namespaces map[string]*principalNamespace
+	prunedAt   time.Time
+
+	index keyIndex
+}
+
+type principalNamespace struct {
+	ns     *Namespace
+	usedAt time.Time
+}
+
+// NewPrivateCache allocate a PrivateCache extracting the principals with principal, the versions of the principals
+// are stored in store, usually the cache store itself, and the entries beyond the quota are deleted from it
+func NewPrivateCache(store persist.CacheStore, principal PrincipalFunc, opts ...PrivateCacheOption) *PrivateCache {
+	p := &PrivateCache{
+		store:         store,
+		principal:     principal,
+		namespaceOpts: []NamespaceOption{WithNamespaceVersionTTL(defaultPrincipalVersionTTL)},
+		namespaces:    map[string]*principalNamespace{},
+		index:         newKeyIndex(),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// WithPrivateCache enable the private cache mode.
+// The requests with a principal are cached per principal, the anonymous ones are cached as usual.
+// If the version of the principal can't be read from the store, the request is not cached.
+// Without it, the requests carrying Authorization are never cached, see CacheabilityPolicy.Authorization.
+func WithPrivateCache(p *PrivateCache) Option {
+	return func(c *Config) {
+		if p != nil && p.principal != nil {
+			c.privateCache = p
+			c.eventHandlers = append(c.eventHandlers, p)
+		}
+	}
+}
+
+// HandleEvent implements EventHandler
+func (p *PrivateCache) HandleEvent(c *gin.Context, event Event) {
+	switch event.Type {
+	case EventStored:
+		principal, ok := p.principal(c)
+		if !ok {
+			return
+		}
+
+		now := time.Now()
+		count := p.index.add(principal, event.Key, keyIndexEntry{
+			store:    event.Strategy.CacheStore,
+			storedAt: now,
+			expireAt: now.Add(event.Expire),
+		})
+		if p.maxEntries > 0 && count > p.maxEntries {
+			if key, oldest, ok := p.index.removeOldest(principal); ok {
+				_ = deleteWithSpan(c.Request.Context(), oldest.store, key)
+			}
+		}
+	case EventEvicted:
+		p.index.remove(event.Key)
+	}
+}
+
+// Keys returns the cache keys of principal stored by this process, sorted
+func (p *PrivateCache) Keys(principal string) []string {
+	return p.index.groupKeys(principal)
+}
+
+// Purge bumps the version of principal, e.g. on logout or permission change, so that its entries become unreachable
+// and expire naturally. It takes effect immediately on this replica, and after the refresh interval of the namespaces
+// on the others.
+func (p *PrivateCache) Purge(principal string) error {
+	if _, err := p.namespace(principal).Bump(); err != nil {
+		return err
+	}
+
+	p.index.removeGroup(principal)
+	return nil
+}
+
+// namespace returns the namespace of the version of principal, and drops the idle ones
+func (p *PrivateCache) namespace(principal string) *Namespace {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := p.namespaces[principal]
+	if !ok {
+		entry = &principalNamespace{ns: NewNamespace("principal:"+principal, p.store, p.namespaceOpts...)}
+		p.namespaces[principal] = entry
+	}
+	entry.usedAt = now
+
+	// an idle namespace would read the store on its next use anyway, so dropping it loses nothing
+	if now.Sub(p.prunedAt) >= entry.ns.refreshInterval {
+		p.prunedAt = now
+		for name, idle := range p.namespaces {
+			if now.Sub(idle.usedAt) >= idle.ns.refreshInterval {
+				delete(p.namespaces, name)
+			}
+		}
+	}
+	return entry.ns
+}
+
+// privateScope returns the key prefix of the principal of the request, empty for the anonymous requests
+func privateScope(c *gin.Context, cfg *Config) (string, error) {
+	hasAuthorization := c.GetHeader("Authorization") != ""
+
+	if cfg.privateCache == nil {
+		if hasAuthorization && cfg.cacheabilityPolicy.Authorization != CacheabilityStore {
+			return "", ErrAuthorizationNotCacheable
+		}
+		return "", nil
+	}
+
+	principal, ok := cfg.privateCache.principal(c)
+	if !ok {
+		if hasAuthorization && cfg.cacheabilityPolicy.Authorization != CacheabilityStore {
+			return "", ErrNoPrincipal
+		}
+		return "", nil
+	}
+	return "principal:" + keyPartEscaper.Replace(principal) + keyPartSeparator, nil
+}
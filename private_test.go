@@ -0,0 +1,298 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizationNotCached(t *testing.T) {
+	calls := 0
+	recorder := &eventRecorder{}
+	engine := gin.New()
+	engine.GET("/profile",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second, WithEventHandler(recorder)),
+		func(c *gin.Context) {
+			calls++
+			c.String(http.StatusOK, "profile of %s", c.GetHeader("Authorization"))
+		},
+	)
+
+	for _, token := range []string{"Bearer alice", "Bearer bob"} {
+		req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+		req.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		assert.Equal(t, "profile of "+token, w.Body.String())
+	}
+	assert.Equal(t, 2, calls)
+
+	skipped := recorder.last()
+	assert.Equal(t, EventSkipped, skipped.Type)
+	assert.Equal(t, ErrAuthorizationNotCacheable, skipped.Err)
+	assert.Equal(t, http.StatusOK, skipped.Status)
+}
+
+func TestPrivateCache(t *testing.T) {
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+	privateCache := NewPrivateCache(memoryStore, PrincipalFromContext("user"))
+
+	calls := 0
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		// the authentication middleware
+		if token := c.GetHeader("Authorization"); token != "" {
+			c.Set("user", token[len("Bearer "):])
+		}
+	})
+	engine.GET("/profile",
+		CacheByRequestURI(memoryStore, 3*time.Second, WithPrivateCache(privateCache)),
+		func(c *gin.Context) {
+			calls++
+			c.String(http.StatusOK, "profile of %s", c.GetString("user"))
+		},
+	)
+
+	serve := func(user string) string {
+		req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+		if user != "" {
+			req.Header.Set("Authorization", "Bearer "+user)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	assert.Equal(t, "profile of alice", serve("alice"))
+	assert.Equal(t, "profile of bob", serve("bob"))
+	assert.Equal(t, "profile of alice", serve("alice"))
+	assert.Equal(t, "profile of bob", serve("bob"))
+	assert.Equal(t, 2, calls)
+
+	// the anonymous requests are cached publicly
+	assert.Equal(t, "profile of ", serve(""))
+	assert.Equal(t, "profile of ", serve(""))
+	assert.Equal(t, 3, calls)
+
+	version, err := privateCache.namespace("alice").Version()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ns:principal:alice@" + version + "|principal:alice|/profile"}, privateCache.Keys("alice"))
+
+	require.NoError(t, privateCache.Purge("alice"))
+	assert.Empty(t, privateCache.Keys("alice"))
+
+	assert.Equal(t, "profile of alice", serve("alice"))
+	assert.Equal(t, "profile of bob", serve("bob"))
+	assert.Equal(t, 4, calls)
+}
+
+func TestPrivateCacheQuota(t *testing.T) {
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+	privateCache := NewPrivateCache(memoryStore, PrincipalFromHeader("X-Tenant"), WithPrincipalQuota(2))
+
+	calls := 0
+	engine := gin.New()
+	engine.GET("/items/:id",
+		CacheByRequestURI(memoryStore, 3*time.Second, WithPrivateCache(privateCache)),
+		func(c *gin.Context) {
+			calls++
+			c.String(http.StatusOK, "item")
+		},
+	)
+
+	serve := func(uri string) {
+		req := httptest.NewRequest(http.MethodGet, uri, nil)
+		req.Header.Set("X-Tenant", "acme")
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+		time.Sleep(time.Millisecond)
+	}
+
+	serve("/items/1")
+	serve("/items/2")
+	serve("/items/3")
+	version, err := privateCache.namespace("acme").Version()
+	require.NoError(t, err)
+	nsPrefix := "ns:principal:acme@" + version + "|"
+	assert.Equal(t, []string{nsPrefix + "principal:acme|/items/2", nsPrefix + "principal:acme|/items/3"}, privateCache.Keys("acme"))
+
+	// the oldest entry is deleted
+	var respCache *ResponseCache
+	assert.Equal(t, persist.ErrCacheMiss, memoryStore.Get(nsPrefix+"principal:acme|/items/1", &respCache))
+	serve("/items/1")
+	assert.Equal(t, 4, calls)
+}
+
+func TestPrivateCacheQuotaStrategyStore(t *testing.T) {
+	// the versions and the entries are kept in different stores
+	versionStore := persist.NewMemoryStore(1 * time.Minute)
+	entryStore := persist.NewMemoryStore(1 * time.Minute)
+	privateCache := NewPrivateCache(versionStore, PrincipalFromHeader("X-Tenant"), WithPrincipalQuota(1))
+
+	engine := gin.New()
+	engine.GET("/items/:id",
+		CacheByRequestURI(versionStore, 3*time.Second,
+			WithPrivateCache(privateCache),
+			WithCacheStrategyByRequest(func(c *gin.Context) (bool, Strategy) {
+				return true, Strategy{CacheKey: c.Request.RequestURI, CacheStore: entryStore}
+			}),
+		),
+		func(c *gin.Context) {
+			c.String(http.StatusOK, "item")
+		},
+	)
+
+	serve := func(uri string) {
+		req := httptest.NewRequest(http.MethodGet, uri, nil)
+		req.Header.Set("X-Tenant", "acme")
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+		time.Sleep(time.Millisecond)
+	}
+
+	serve("/items/1")
+	serve("/items/2")
+	version, err := privateCache.namespace("acme").Version()
+	require.NoError(t, err)
+	nsPrefix := "ns:principal:acme@" + version + "|"
+
+	// the oldest entry is deleted from the store of the strategy
+	var respCache *ResponseCache
+	assert.Equal(t, persist.ErrCacheMiss, entryStore.Get(nsPrefix+"principal:acme|/items/1", &respCache))
+	require.NoError(t, entryStore.Get(nsPrefix+"principal:acme|/items/2", &respCache))
+}
+
+func TestPrivateCachePurgeReplicas(t *testing.T) {
+	// the replicas share the cache store, each has its own private cache
+	sharedStore := persist.NewMemoryStore(1 * time.Minute)
+
+	calls := 0
+	newReplica := func() (*gin.Engine, *PrivateCache) {
+		privateCache := NewPrivateCache(sharedStore, PrincipalFromHeader("X-Tenant"),
+			WithPrincipalNamespaceOptions(WithNamespaceRefreshInterval(50*time.Millisecond)))
+		engine := gin.New()
+		engine.GET("/items", CacheByRequestURI(sharedStore, 3*time.Second, WithPrivateCache(privateCache)), func(c *gin.Context) {
+			calls++
+			c.String(http.StatusOK, "value-%d", calls)
+		})
+		return engine, privateCache
+	}
+	replicaA, _ := newReplica()
+	replicaB, privateCacheB := newReplica()
+
+	serve := func(engine *gin.Engine, tenant string) string {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("X-Tenant", tenant)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	assert.Equal(t, "value-1", serve(replicaA, "acme"))
+	assert.Equal(t, "value-1", serve(replicaB, "acme"))
+	assert.Equal(t, "value-2", serve(replicaA, "other"))
+
+	// the entry stored by replica A is purged by replica B
+	require.NoError(t, privateCacheB.Purge("acme"))
+	assert.Equal(t, "value-3", serve(replicaB, "acme"))
+
+	// replica A sees the purge after the refresh interval, the other principals are kept
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "value-3", serve(replicaA, "acme"))
+	assert.Equal(t, "value-2", serve(replicaB, "other"))
+	assert.Equal(t, 3, calls)
+}
+
+func TestPrivateCachePurgeExpired(t *testing.T) {
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+	privateCache := NewPrivateCache(memoryStore, PrincipalFromHeader("X-Tenant"))
+
+	engine := gin.New()
+	engine.GET("/items/:id",
+		CacheByRequestURI(memoryStore, 50*time.Millisecond, WithPrivateCache(privateCache)),
+		func(c *gin.Context) {
+			c.String(http.StatusOK, "item")
+		},
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/items/1", nil)
+	req.Header.Set("X-Tenant", "acme")
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+	time.Sleep(200 * time.Millisecond)
+
+	// the expired entry is gone from the store, purging it isn't an error
+	require.NoError(t, privateCache.Purge("acme"))
+	assert.Empty(t, privateCache.Keys("acme"))
+}
+
+func TestPrivateCacheNamespaces(t *testing.T) {
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+	privateCache := NewPrivateCache(memoryStore, PrincipalFromHeader("X-Tenant"),
+		WithPrincipalNamespaceOptions(WithNamespaceRefreshInterval(20*time.Millisecond)))
+
+	engine := gin.New()
+	engine.GET("/items", CacheByRequestURI(memoryStore, 3*time.Second, WithPrivateCache(privateCache)),
+		func(c *gin.Context) {
+			c.String(http.StatusOK, "items")
+		},
+	)
+
+	serve := func(tenant string) {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("X-Tenant", tenant)
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for i := 0; i < 100; i++ {
+		serve(fmt.Sprintf("tenant-%d", i))
+	}
+
+	// the versions of the principals never purged aren't stored
+	var version string
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, persist.ErrCacheMiss, memoryStore.Get(namespaceVersionKeyPrefix+fmt.Sprintf("principal:tenant-%d", i), &version))
+	}
+
+	// the idle namespaces are dropped
+	time.Sleep(50 * time.Millisecond)
+	serve("acme")
+	privateCache.mu.Lock()
+	assert.Len(t, privateCache.namespaces, 1)
+	privateCache.mu.Unlock()
+
+	// the version of a purged principal is stored
+	require.NoError(t, privateCache.Purge("acme"))
+	require.NoError(t, memoryStore.Get(namespaceVersionKeyPrefix+"principal:acme", &version))
+	assert.NotEqual(t, namespaceInitialVersion, version)
+}
+
+func TestPrivateCacheNoPrincipal(t *testing.T) {
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+
+	calls := 0
+	recorder := &eventRecorder{}
+	engine := gin.New()
+	engine.GET("/profile",
+		CacheByRequestURI(memoryStore, 3*time.Second,
+			WithPrivateCache(NewPrivateCache(memoryStore, PrincipalFromContext("missing"))),
+			WithEventHandler(recorder),
+		),
+		func(c *gin.Context) {
+			calls++
+			c.String(http.StatusOK, "profile")
+		},
+	)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+		req.Header.Set("Authorization", "Bearer alice")
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, ErrNoPrincipal, recorder.last().Err)
+}
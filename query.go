@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// QueryNormalization describe how the query params are normalized for the cache key.
+// The names in Allow and Deny may be glob patterns of path.Match, e.g. "utm_*".
+type QueryNormalization struct {
+	// Allow keeps only the matched params if not empty
+	Allow []string
+
+	// Deny drops the matched params, e.g. the tracking or cache-busting params
+	Deny []string
+
+	// DropEmpty drops the params without value, e.g. "a=" and "a"
+	DropEmpty bool
+
+	// LowercaseKeys lowercases the names before matching Allow and Deny
+	LowercaseKeys bool
+
+	// LowercaseValues lowercases the values
+	LowercaseValues bool
+}
+
+// WithQueryNormalization normalize the query params of the key of CacheByRequestURI.
+// The params are sorted like IgnoreQueryOrder, and re-encoded, so that the different percent-encodings
+// of the same value share the key.
+func WithQueryNormalization(normalization QueryNormalization) Option {
+	return func(c *Config) {
+		c.queryNormalization = &normalization
+	}
+}
+
+// TrackingQueryParams the common params which don't change the response, to be used in QueryNormalization.Deny
+func TrackingQueryParams() []string {
+	return []string{
+		"utm_*",
+		"fbclid",
+		"gclid",
+		"msclkid",
+		"_",
+	}
+}
+
+// normalize returns the normalized copy of values
+func (n *QueryNormalization) normalize(values url.Values) url.Values {
+	normalized := make(url.Values, len(values))
+	for key, vals := range values {
+		if n.LowercaseKeys {
+			key = strings.ToLower(key)
+		}
+		if len(n.Allow) > 0 && !matchQueryParam(n.Allow, key) {
+			continue
+		}
+		if matchQueryParam(n.Deny, key) {
+			continue
+		}
+
+		for _, val := range vals {
+			if n.DropEmpty && val == "" {
+				continue
+			}
+			if n.LowercaseValues {
+				val = strings.ToLower(val)
+			}
+			normalized[key] = append(normalized[key], val)
+		}
+	}
+	return normalized
+}
+
+// matchQueryParam returns whether key matches any of patterns
+func matchQueryParam(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if pattern == key {
+			return true
+		}
+		if matched, err := path.Match(pattern, key); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
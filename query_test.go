@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryNormalization(t *testing.T) {
+	normalization := &QueryNormalization{
+		Deny:            TrackingQueryParams(),
+		DropEmpty:       true,
+		LowercaseKeys:   true,
+		LowercaseValues: true,
+	}
+
+	val, err := getRequestUriIgnoreQueryOrder("/test?utm_source=x&Lang=EN&b=&_=1690000&a=%7e1&fbclid=y", normalization)
+	require.NoError(t, err)
+	assert.Equal(t, "/test?a=~1&lang=en", val)
+
+	// the percent-encoded separators stay encoded
+	val, err = getRequestUriIgnoreQueryOrder("/test?a=1%26b%3D2", normalization)
+	require.NoError(t, err)
+	assert.Equal(t, "/test?a=1%26b%3D2", val)
+
+	val, err = getRequestUriIgnoreQueryOrder("/test?utm_medium=email", normalization)
+	require.NoError(t, err)
+	assert.Equal(t, "/test", val)
+
+	allow := &QueryNormalization{Allow: []string{"page", "filter_*"}}
+	val, err = getRequestUriIgnoreQueryOrder("/test?sort=asc&filter_color=red&page=2", allow)
+	require.NoError(t, err)
+	assert.Equal(t, "/test?filter_color=red&page=2", val)
+}
+
+func TestCacheByRequestURIWithQueryNormalization(t *testing.T) {
+	calls := 0
+	engine := gin.New()
+	engine.GET("/cache",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second,
+			WithQueryNormalization(QueryNormalization{Deny: TrackingQueryParams()}),
+		),
+		func(c *gin.Context) {
+			calls++
+			c.String(http.StatusOK, "value")
+		},
+	)
+
+	for _, uri := range []string{"/cache?b=2&a=1", "/cache?a=1&utm_source=x&b=2", "/cache?_=1690000&b=2&a=1", "/cache?a=2"} {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, uri, nil))
+	}
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type refreshAheadContextKey struct{}
+
+func isRefreshAheadRequest(c *gin.Context) bool {
+	refreshing, _ := c.Request.Context().Value(refreshAheadContextKey{}).(bool)
+	return refreshing
+}
+
+func shouldRefreshAhead(cfg *Config, strategy Strategy, cacheDuration time.Duration, respCache *ResponseCache) bool {
+	if cfg.refreshAheadHandler == nil || respCache.CreatedAt.IsZero() {
+		return false
+	}
+
+	factor := cfg.refreshAheadFactor
+	if strategy.RefreshAheadFactor > 0 {
+		factor = strategy.RefreshAheadFactor
+	}
+	if factor <= 0 {
+		return false
+	}
+
+	return time.Since(respCache.CreatedAt) >= time.Duration(float64(cacheDuration)*factor)
+}
+
+// refreshAhead replay the request in background, the cache middleware will skip reading and overwrite the entry.
+// refreshingKeys dedupe the concurrent refreshes of the same key.
+func refreshAhead(c *gin.Context, cfg *Config, refreshingKeys *sync.Map, cacheKey string) {
+	if _, refreshing := refreshingKeys.LoadOrStore(cacheKey, struct{}{}); refreshing {
+		return
+	}
+
+	ctx := context.WithValue(context.Background(), refreshAheadContextKey{}, true)
+	req := c.Request.Clone(ctx)
+	req.Body = http.NoBody
+	if c.Request.GetBody != nil {
+		// the body restored by CacheByRequestBody, it's part of the key
+		if body, err := c.Request.GetBody(); err == nil {
+			req.Body = body
+		}
+	}
+
+	go func() {
+		defer refreshingKeys.Delete(cacheKey)
+		cfg.refreshAheadHandler.ServeHTTP(&discardResponseWriter{header: http.Header{}}, req)
+	}()
+}
+
+// discardResponseWriter is the response writer of the replayed requests, nobody reads the response
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(int) {
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshAhead(t *testing.T) {
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+
+	var backendCount int32
+	_, engine := gin.CreateTestContext(httptest.NewRecorder())
+	engine.GET("/cache",
+		CacheByRequestURI(memoryStore, 1*time.Second, WithRefreshAhead(engine, 0.5)),
+		func(c *gin.Context) {
+			c.String(http.StatusOK, fmt.Sprintf("count:%d", atomic.AddInt32(&backendCount, 1)))
+		},
+	)
+
+	serve := func() string {
+		testWriter := httptest.NewRecorder()
+		engine.ServeHTTP(testWriter, httptest.NewRequest(http.MethodGet, "/cache", nil))
+		return testWriter.Body.String()
+	}
+
+	assert.Equal(t, "count:1", serve())
+	assert.Equal(t, "count:1", serve())
+
+	// more than half of the TTL elapsed, the hit still replies the old entry but triggers a refresh
+	time.Sleep(600 * time.Millisecond)
+	assert.Equal(t, "count:1", serve())
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&backendCount))
+
+	// the original entry would have expired by now
+	time.Sleep(400 * time.Millisecond)
+	assert.Equal(t, "count:2", serve())
+}
+
+func TestRefreshAheadStrategyFactor(t *testing.T) {
+	cfg := newConfigByOpts(WithRefreshAhead(http.NotFoundHandler(), 0.8))
+
+	respCache := &ResponseCache{CreatedAt: time.Now().Add(-6 * time.Second)}
+	assert.False(t, shouldRefreshAhead(cfg, Strategy{}, 10*time.Second, respCache))
+	assert.True(t, shouldRefreshAhead(cfg, Strategy{RefreshAheadFactor: 0.5}, 10*time.Second, respCache))
+
+	// entries cached before CreatedAt is recorded are never refreshed ahead
+	assert.False(t, shouldRefreshAhead(cfg, Strategy{RefreshAheadFactor: 0.5}, 10*time.Second, &ResponseCache{}))
+}
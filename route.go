@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+)
+
+// ParamNormalizer returns the normalized value of the path param key, e.g. without leading zeros
+type ParamNormalizer func(key, value string) string
+
+// NormalizeNumericParams strips the leading zeros of the numeric params, so /users/042 shares the key of /users/42
+func NormalizeNumericParams(key, value string) string {
+	if value == "" || strings.TrimLeft(value, "0123456789") != "" {
+		return value
+	}
+
+	trimmed := strings.TrimLeft(value, "0")
+	if trimmed == "" {
+		return "0"
+	}
+	return trimmed
+}
+
+// WithParamNormalizer set the normalizer of the path params of CacheByRoute
+func WithParamNormalizer(normalizer ParamNormalizer) Option {
+	return func(c *Config) {
+		if normalizer != nil {
+			c.paramNormalizer = normalizer
+		}
+	}
+}
+
+// CacheByRoute a shortcut function for caching response by the route template, e.g. /users/:id, and the path params.
+// The query params are discarded. The trailing slashes of the catch-all params are trimmed,
+// and the params are normalized by WithParamNormalizer.
+// Use WithRouteIndex to list or purge the entries of a route.
+func CacheByRoute(defaultCacheStore persist.CacheStore, defaultExpire time.Duration, opts ...Option) gin.HandlerFunc {
+	cfg := newConfigByOpts(opts...)
+
+	if cfg.getCacheStrategyByRequest == nil {
+		cfg.getCacheStrategyByRequest = func(c *gin.Context) (bool, Strategy) {
+			return true, Strategy{
+				CacheKey: routeKey(c, cfg.paramNormalizer),
+			}
+		}
+	}
+
+	return cache(defaultCacheStore, defaultExpire, cfg)
+}
+
+// routeKey returns the key of the route template and the normalized params, the path if no route is matched
+func routeKey(c *gin.Context, normalizer ParamNormalizer) string {
+	route := c.FullPath()
+	if route == "" {
+		return "path:" + keyPartEscaper.Replace(c.Request.URL.Path)
+	}
+
+	values := url.Values{}
+	for _, param := range c.Params {
+		value := param.Value
+		if len(value) > 1 && strings.HasSuffix(value, "/") {
+			value = strings.TrimRight(value, "/")
+		}
+		if normalizer != nil {
+			value = normalizer(param.Key, value)
+		}
+		values.Add(param.Key, value)
+	}
+
+	return "route:" + keyPartEscaper.Replace(route) + keyPartSeparator + "params:" + encodeKeyValues(values)
+}
+
+// RouteIndex folds a version per route template into the keys of the middlewares using WithRouteIndex,
+// the versions are namespaces stored in the store shared by the replicas, so that Purge invalidates
+// the entries of a route on all of them. It also records the keys stored by this process for Routes and Keys.
+// The expired entries are dropped lazily, use NotifyEvictions to drop the evicted ones as well.
+type RouteIndex struct {
+	store persist.CacheStore
+	opts  []NamespaceOption
+
+	mu         sync.Mutex
+	namespaces map[string]*Namespace
+
+	index keyIndex
+}
+
+// NewRouteIndex allocate a RouteIndex storing the versions of the routes in store, usually the cache store itself
+func NewRouteIndex(store persist.CacheStore, opts ...NamespaceOption) *RouteIndex {
+	return &RouteIndex{
+		store:      store,
+		opts:       opts,
+		namespaces: map[string]*Namespace{},
+		index:      newKeyIndex(),
+	}
+}
+
+// WithRouteIndex fold the versions of the routes into the keys, and record the stored entries in index.
+// If a version can't be read from the store, the request is not cached.
+func WithRouteIndex(index *RouteIndex) Option {
+	return func(c *Config) {
+		if index != nil {
+			c.routeIndex = index
+			c.eventHandlers = append(c.eventHandlers, index)
+		}
+	}
+}
+
+// HandleEvent implements EventHandler
+func (r *RouteIndex) HandleEvent(c *gin.Context, event Event) {
+	switch event.Type {
+	case EventStored:
+		route := c.FullPath()
+		if route == "" {
+			return
+		}
+		now := time.Now()
+		r.index.add(route, event.Key, keyIndexEntry{
+			store:    event.Strategy.CacheStore,
+			storedAt: now,
+			expireAt: now.Add(event.Expire),
+		})
+	case EventEvicted:
+		r.index.remove(event.Key)
+	}
+}
+
+// Routes returns the routes having entries stored by this process, sorted
+func (r *RouteIndex) Routes() []string {
+	return r.index.groupNames()
+}
+
+// Keys returns the cache keys of route stored by this process, sorted
+func (r *RouteIndex) Keys(route string) []string {
+	return r.index.groupKeys(route)
+}
+
+// Purge bumps the version of route, so that its entries become unreachable and expire naturally.
+// It takes effect immediately on this replica, and after the refresh interval of the namespaces on the others.
+func (r *RouteIndex) Purge(route string) error {
+	if _, err := r.namespace(route).Bump(); err != nil {
+		return err
+	}
+
+	r.index.removeGroup(route)
+	return nil
+}
+
+// namespace returns the namespace of the version of route
+func (r *RouteIndex) namespace(route string) *Namespace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ns, ok := r.namespaces[route]
+	if !ok {
+		ns = NewNamespace("route:"+route, r.store, r.opts...)
+		r.namespaces[route] = ns
+	}
+	return ns
+}
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheByRoute(t *testing.T) {
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+	index := NewRouteIndex(memoryStore)
+
+	calls := 0
+	engine := gin.New()
+	cacheMiddleware := CacheByRoute(memoryStore, 3*time.Second,
+		WithParamNormalizer(NormalizeNumericParams),
+		WithRouteIndex(index),
+	)
+	handler := func(c *gin.Context) {
+		calls++
+		c.String(http.StatusOK, "value")
+	}
+	engine.GET("/users/:id", cacheMiddleware, handler)
+	engine.GET("/files/*path", cacheMiddleware, handler)
+
+	serve := func(uri string) {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, uri, nil))
+	}
+
+	serve("/users/42")
+	serve("/users/042")
+	serve("/users/42?utm_source=x")
+	assert.Equal(t, 1, calls)
+
+	serve("/files/a/b")
+	serve("/files/a/b/")
+	assert.Equal(t, 2, calls)
+
+	serve("/users/7")
+	assert.Equal(t, 3, calls)
+
+	assert.Equal(t, []string{"/files/*path", "/users/:id"}, index.Routes())
+	version, err := index.namespace("/users/:id").Version()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"ns:route:/users/:id@" + version + "|route:/users/:id|params:id=42",
+		"ns:route:/users/:id@" + version + "|route:/users/:id|params:id=7",
+	}, index.Keys("/users/:id"))
+
+	require.NoError(t, index.Purge("/users/:id"))
+	assert.Empty(t, index.Keys("/users/:id"))
+	assert.Equal(t, []string{"/files/*path"}, index.Routes())
+
+	serve("/users/42")
+	assert.Equal(t, 4, calls)
+
+	// the entries of the other routes are kept
+	serve("/files/a/b")
+	assert.Equal(t, 4, calls)
+}
+
+func TestRouteIndexPurgeReplicas(t *testing.T) {
+	// the replicas share the cache store, each has its own index
+	sharedStore := persist.NewMemoryStore(1 * time.Minute)
+
+	calls := 0
+	newReplica := func() (*gin.Engine, *RouteIndex) {
+		index := NewRouteIndex(sharedStore, WithNamespaceRefreshInterval(50*time.Millisecond))
+		engine := gin.New()
+		engine.GET("/users/:id", CacheByRoute(sharedStore, 3*time.Second, WithRouteIndex(index)), func(c *gin.Context) {
+			calls++
+			c.String(http.StatusOK, "value-%d", calls)
+		})
+		return engine, index
+	}
+	replicaA, _ := newReplica()
+	replicaB, indexB := newReplica()
+
+	serve := func(engine *gin.Engine) string {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/42", nil))
+		return w.Body.String()
+	}
+
+	assert.Equal(t, "value-1", serve(replicaA))
+	assert.Equal(t, "value-1", serve(replicaB))
+
+	// the entry stored by replica A is purged by replica B
+	require.NoError(t, indexB.Purge("/users/:id"))
+	assert.Equal(t, "value-2", serve(replicaB))
+
+	// replica A sees the purge after the refresh interval
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "value-2", serve(replicaA))
+	assert.Equal(t, 2, calls)
+}
+
+func TestRouteIndexKeysServeStale(t *testing.T) {
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+	index := NewRouteIndex(memoryStore)
+
+	engine := gin.New()
+	engine.GET("/users/:id",
+		CacheByRoute(memoryStore, 50*time.Millisecond, WithRouteIndex(index), WithServeStale(time.Second)),
+		func(c *gin.Context) {
+			c.String(http.StatusOK, "user")
+		},
+	)
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/42", nil))
+
+	// the stale entry is still in the store, so it's still listed
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, index.Keys("/users/:id"), 1)
+}
+
+func TestNormalizeNumericParams(t *testing.T) {
+	assert.Equal(t, "42", NormalizeNumericParams("id", "0042"))
+	assert.Equal(t, "0", NormalizeNumericParams("id", "000"))
+	assert.Equal(t, "0x42", NormalizeNumericParams("id", "0x42"))
+	assert.Equal(t, "", NormalizeNumericParams("id", ""))
+}
@@ -0,0 +1,445 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// Rules is the declarative cache configuration of the routes, usually loaded by ParseRules or LoadRulesFile.
+//
+//	rules:
+//	  - name: products
+//	    methods: [GET]
+//	    route: /products/:id
+//	    query: {preview: "!"}
+//	    ttl: 5m
+//	    key: {route: true, params: ["*"], query: [currency]}
+//	    store: redis
+//	    statuses: [200, 404]
+//	    bypass: {headers: {Cache-Control: "~no-cache"}}
+//
+// The rules are matched in order and the first matching rule wins, the requests matching no rule are not cached.
+type Rules struct {
+	Rules []Rule `yaml:"rules" json:"rules"`
+}
+
+// Rule describe which requests are cached and how.
+//
+// The values of Headers, Query and the bypass conditions are predicates: "*" means present,
+// "!" means absent, a leading "~" means a regular expression, otherwise the value must be equal.
+type Rule struct {
+	// Name identifies the rule in the errors and scopes its cache keys, default the index of the rule
+	Name string `yaml:"name" json:"name"`
+
+	// Methods the request method is one of, default GET
+	Methods []string `yaml:"methods" json:"methods"`
+
+	// Route the gin route pattern of the request equals, e.g. /users/:id
+	Route string `yaml:"route" json:"route"`
+
+	// PathRegex the request path matches
+	PathRegex string `yaml:"path_regex" json:"path_regex"`
+
+	// Headers the request headers match, all of them
+	Headers map[string]string `yaml:"headers" json:"headers"`
+
+	// Query the query params match, all of them
+	Query map[string]string `yaml:"query" json:"query"`
+
+	// TTL the cache duration, e.g. "30s" or 30, zero means the default expiration of the middleware
+	TTL RuleDuration `yaml:"ttl" json:"ttl"`
+
+	// Key the parts of the request in the cache key
+	Key KeyRecipe `yaml:"key" json:"key"`
+
+	// Store the name of the cache store passed to Compile, empty means the default store of the middleware
+	Store string `yaml:"store" json:"store"`
+
+	// Statuses the cacheable response statuses, default 2xx
+	Statuses []int `yaml:"statuses" json:"statuses"`
+
+	// Bypass the conditions skipping the cache for the matched requests
+	Bypass RuleConditions `yaml:"bypass" json:"bypass"`
+}
+
+// RuleConditions is matched if any of its predicates matches
+type RuleConditions struct {
+	Headers map[string]string `yaml:"headers" json:"headers"`
+	Query   map[string]string `yaml:"query" json:"query"`
+	Cookies map[string]string `yaml:"cookies" json:"cookies"`
+}
+
+// KeyRecipe describe the parts of the cache key, they're added to a KeyBuilder in the order of the fields.
+// "*" in Params or Query means all of them. The empty recipe means the path and all the query params.
+// The path is always added unless the recipe has Path, or Route together with Params, so that the paths
+// matched by a rule never share an entry.
+// The keys are prefixed with the rule, and with the method if the rule allows several methods,
+// so that the overlapping rules never share an entry.
+type KeyRecipe struct {
+	Method      bool     `yaml:"method" json:"method"`
+	Route       bool     `yaml:"route" json:"route"`
+	Path        bool     `yaml:"path" json:"path"`
+	Params      []string `yaml:"params" json:"params"`
+	Query       []string `yaml:"query" json:"query"`
+	QueryExcept []string `yaml:"query_except" json:"query_except"`
+	Headers     []string `yaml:"headers" json:"headers"`
+	Cookies     []string `yaml:"cookies" json:"cookies"`
+}
+
+// RuleDuration is a time.Duration decoded from a duration string like "1m30s" or a number of seconds
+type RuleDuration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (d *RuleDuration) UnmarshalYAML(value *yaml.Node) error {
+	return d.parse(value.Value)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *RuleDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		s = string(data)
+	}
+	return d.parse(s)
+}
+
+func (d *RuleDuration) parse(s string) error {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		*d = RuleDuration(seconds * float64(time.Second))
+		return nil
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("gin-cache: invalid ttl %q", s)
+	}
+	*d = RuleDuration(duration)
+	return nil
+}
+
+// ParseRules decodes the rules from YAML or JSON, the unknown fields are errors so that a misspelled one
+// doesn't silently widen a rule
+func ParseRules(data []byte) (*Rules, error) {
+	rules := &Rules{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(rules); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("gin-cache: parse rules: %w", err)
+	}
+	return rules, nil
+}
+
+// LoadRulesFile reads the rules from the YAML or JSON file of path
+func LoadRulesFile(path string) (*Rules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRules(data)
+}
+
+// Compile validates the rules and compiles them into a single GetCacheStrategyByRequest for WithCacheStrategyByRequest.
+// stores maps the store names of the rules to the cache stores.
+func (r *Rules) Compile(stores map[string]persist.CacheStore) (GetCacheStrategyByRequest, error) {
+	compiled := make([]*compiledRule, 0, len(r.Rules))
+	scopes := make(map[string]bool, len(r.Rules))
+	for i := range r.Rules {
+		name := r.Rules[i].Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		if scopes[name] {
+			return nil, fmt.Errorf("gin-cache: rule %s: duplicate name", name)
+		}
+		scopes[name] = true
+
+		rule, err := compileRule(&r.Rules[i], name, stores)
+		if err != nil {
+			return nil, fmt.Errorf("gin-cache: rule %s: %w", name, err)
+		}
+		compiled = append(compiled, rule)
+	}
+
+	return func(c *gin.Context) (bool, Strategy) {
+		for _, rule := range compiled {
+			if !rule.match(c) {
+				continue
+			}
+			if rule.bypass(c) {
+				return false, Strategy{}
+			}
+
+			return true, Strategy{
+				CacheKey:          rule.keyBuilder.Build(c),
+				CacheStore:        rule.store,
+				CacheDuration:     rule.ttl,
+				CacheableStatuses: rule.statuses,
+			}
+		}
+		return false, Strategy{}
+	}, nil
+}
+
+// requestPredicate matches a value of the request, ok is false if the value is absent
+type requestPredicate func(value string, ok bool) bool
+
+type namedPredicate struct {
+	name      string
+	predicate requestPredicate
+}
+
+type compiledRule struct {
+	methods   []string
+	route     string
+	pathRegex *regexp.Regexp
+	headers   []namedPredicate
+	query     []namedPredicate
+
+	bypassHeaders []namedPredicate
+	bypassQuery   []namedPredicate
+	bypassCookies []namedPredicate
+
+	keyBuilder *KeyBuilder
+	store      persist.CacheStore
+	ttl        time.Duration
+	statuses   []int
+}
+
+func compileRule(rule *Rule, name string, stores map[string]persist.CacheStore) (*compiledRule, error) {
+	compiled := &compiledRule{
+		methods:  []string{http.MethodGet},
+		route:    rule.Route,
+		ttl:      time.Duration(rule.TTL),
+		statuses: rule.Statuses,
+	}
+
+	if len(rule.Methods) > 0 {
+		compiled.methods = make([]string, 0, len(rule.Methods))
+		for _, method := range rule.Methods {
+			compiled.methods = append(compiled.methods, strings.ToUpper(method))
+		}
+	}
+
+	if rule.PathRegex != "" {
+		pathRegex, err := regexp.Compile(rule.PathRegex)
+		if err != nil {
+			return nil, err
+		}
+		compiled.pathRegex = pathRegex
+	}
+
+	if rule.TTL < 0 {
+		return nil, fmt.Errorf("negative ttl %s", time.Duration(rule.TTL))
+	}
+
+	for _, status := range rule.Statuses {
+		if status < 100 || status > 599 {
+			return nil, fmt.Errorf("invalid status %d", status)
+		}
+	}
+
+	if rule.Store != "" {
+		store, ok := stores[rule.Store]
+		if !ok || store == nil {
+			return nil, fmt.Errorf("unknown store %q", rule.Store)
+		}
+		compiled.store = store
+	}
+
+	keyBuilder, err := rule.Key.keyBuilder(name, len(compiled.methods) > 1)
+	if err != nil {
+		return nil, err
+	}
+	compiled.keyBuilder = keyBuilder
+
+	predicates := []struct {
+		values   map[string]string
+		header   bool
+		compiled *[]namedPredicate
+	}{
+		{rule.Headers, true, &compiled.headers},
+		{rule.Query, false, &compiled.query},
+		{rule.Bypass.Headers, true, &compiled.bypassHeaders},
+		{rule.Bypass.Query, false, &compiled.bypassQuery},
+		{rule.Bypass.Cookies, false, &compiled.bypassCookies},
+	}
+	for _, p := range predicates {
+		if *p.compiled, err = compilePredicates(p.values, p.header); err != nil {
+			return nil, err
+		}
+	}
+
+	return compiled, nil
+}
+
+// match returns whether the request matches all the conditions of the rule
+func (rule *compiledRule) match(c *gin.Context) bool {
+	if !containsString(rule.methods, c.Request.Method) {
+		return false
+	}
+	if rule.route != "" && rule.route != c.FullPath() {
+		return false
+	}
+	if rule.pathRegex != nil && !rule.pathRegex.MatchString(c.Request.URL.Path) {
+		return false
+	}
+
+	for _, p := range rule.headers {
+		value, ok := headerValue(c, p.name)
+		if !p.predicate(value, ok) {
+			return false
+		}
+	}
+
+	if len(rule.query) > 0 {
+		query := c.Request.URL.Query()
+		for _, p := range rule.query {
+			value, ok := queryValue(query, p.name)
+			if !p.predicate(value, ok) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// bypass returns whether the request matches any of the bypass conditions of the rule
+func (rule *compiledRule) bypass(c *gin.Context) bool {
+	for _, p := range rule.bypassHeaders {
+		if p.predicate(headerValue(c, p.name)) {
+			return true
+		}
+	}
+
+	if len(rule.bypassQuery) > 0 {
+		query := c.Request.URL.Query()
+		for _, p := range rule.bypassQuery {
+			if p.predicate(queryValue(query, p.name)) {
+				return true
+			}
+		}
+	}
+
+	for _, p := range rule.bypassCookies {
+		value, err := c.Cookie(p.name)
+		if p.predicate(value, err == nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyBuilder returns the builder of the recipe scoped by the rule name,
+// the method is added if the rule allows several methods
+func (recipe KeyRecipe) keyBuilder(name string, methods bool) (*KeyBuilder, error) {
+	if len(recipe.Query) > 0 && len(recipe.QueryExcept) > 0 {
+		return nil, fmt.Errorf("key query and query_except are exclusive")
+	}
+
+	empty := !recipe.Method && !recipe.Route && !recipe.Path && len(recipe.Params) == 0 && len(recipe.Query) == 0 &&
+		len(recipe.QueryExcept) == 0 && len(recipe.Headers) == 0 && len(recipe.Cookies) == 0
+
+	scope := "rule:" + keyPartEscaper.Replace(name)
+	builder := NewKeyBuilder().add(func(*gin.Context) string {
+		return scope
+	})
+	if recipe.Method || methods {
+		builder.Method()
+	}
+	if empty {
+		return builder.Path().Query(), nil
+	}
+
+	if recipe.Route {
+		builder.Route()
+	}
+	if recipe.Path || !recipe.Route || len(recipe.Params) == 0 {
+		builder.Path()
+	}
+	if len(recipe.Params) > 0 {
+		builder.Params(wildcardNames(recipe.Params)...)
+	}
+	if len(recipe.Query) > 0 {
+		builder.Query(wildcardNames(recipe.Query)...)
+	}
+	if len(recipe.QueryExcept) > 0 {
+		builder.QueryExcept(recipe.QueryExcept...)
+	}
+	if len(recipe.Headers) > 0 {
+		builder.Header(recipe.Headers...)
+	}
+	if len(recipe.Cookies) > 0 {
+		builder.Cookie(recipe.Cookies...)
+	}
+	return builder, nil
+}
+
+// wildcardNames returns nil, meaning all the names, if names contains "*"
+func wildcardNames(names []string) []string {
+	if containsString(names, "*") {
+		return nil
+	}
+	return names
+}
+
+func compilePredicates(values map[string]string, header bool) ([]namedPredicate, error) {
+	predicates := make([]namedPredicate, 0, len(values))
+	for name, value := range values {
+		predicate, err := compilePredicate(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if header {
+			name = http.CanonicalHeaderKey(name)
+		}
+		predicates = append(predicates, namedPredicate{name: name, predicate: predicate})
+	}
+	return predicates, nil
+}
+
+func compilePredicate(value string) (requestPredicate, error) {
+	switch {
+	case value == "*":
+		return func(_ string, ok bool) bool { return ok }, nil
+	case value == "!":
+		return func(_ string, ok bool) bool { return !ok }, nil
+	case strings.HasPrefix(value, "~"):
+		re, err := regexp.Compile(value[1:])
+		if err != nil {
+			return nil, err
+		}
+		return func(v string, ok bool) bool { return ok && re.MatchString(v) }, nil
+	default:
+		return func(v string, ok bool) bool { return ok && v == value }, nil
+	}
+}
+
+func headerValue(c *gin.Context, name string) (string, bool) {
+	values, ok := c.Request.Header[name]
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+func queryValue(query map[string][]string, name string) (string, bool) {
+	values, ok := query[name]
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
@@ -0,0 +1,267 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRulesYAML = `
+rules:
+  - name: no-preview
+    route: /products/:id
+    query: {preview: "*"}
+    bypass: {query: {preview: "*"}}
+  - name: products
+    methods: [get]
+    route: /products/:id
+    ttl: 5m
+    key: {route: true, params: ["*"], query: [currency]}
+    store: secondary
+    statuses: [200, 404]
+    bypass:
+      headers: {Cache-Control: "~no-cache"}
+      cookies: {session: "*"}
+  - name: search
+    path_regex: ^/search/
+    headers: {X-Tenant: "~^[a-z]+$"}
+    ttl: 30
+    key: {path: true, query_except: [utm_source], headers: [X-Tenant]}
+`
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]byte(testRulesYAML))
+	require.NoError(t, err)
+	require.Len(t, rules.Rules, 3)
+
+	products := rules.Rules[1]
+	assert.Equal(t, "/products/:id", products.Route)
+	assert.Equal(t, RuleDuration(5*time.Minute), products.TTL)
+	assert.Equal(t, []string{"*"}, products.Key.Params)
+	assert.Equal(t, []int{200, 404}, products.Statuses)
+	assert.Equal(t, "~no-cache", products.Bypass.Headers["Cache-Control"])
+	assert.Equal(t, RuleDuration(30*time.Second), rules.Rules[2].TTL)
+
+	jsonRules, err := ParseRules([]byte(`{"rules": [{"name": "a", "route": "/a", "ttl": "1m30s", "statuses": [200]}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, RuleDuration(90*time.Second), jsonRules.Rules[0].TTL)
+
+	_, err = ParseRules([]byte(`rules: [{ttl: forever}]`))
+	assert.Error(t, err)
+
+	// a misspelled field is an error rather than ignored
+	_, err = ParseRules([]byte(`rules: [{name: a, path_regexp: "^/a"}]`))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "path_regexp")
+	}
+
+	empty, err := ParseRules(nil)
+	require.NoError(t, err)
+	assert.Empty(t, empty.Rules)
+}
+
+func TestRulesCompileError(t *testing.T) {
+	stores := map[string]persist.CacheStore{"memory": persist.NewMemoryStore(time.Minute)}
+
+	for _, rule := range []Rule{
+		{Name: "store", Store: "redis"},
+		{Name: "regex", PathRegex: "("},
+		{Name: "predicate", Headers: map[string]string{"Accept": "~("}},
+		{Name: "status", Statuses: []int{2000}},
+		{Name: "ttl", TTL: RuleDuration(-time.Second)},
+		{Name: "key", Key: KeyRecipe{Query: []string{"a"}, QueryExcept: []string{"b"}}},
+	} {
+		_, err := (&Rules{Rules: []Rule{rule}}).Compile(stores)
+		if assert.Error(t, err, rule.Name) {
+			assert.Contains(t, err.Error(), "rule "+rule.Name)
+		}
+	}
+
+	_, err := (&Rules{Rules: []Rule{{Name: "a"}, {Name: "a"}}}).Compile(stores)
+	assert.EqualError(t, err, "gin-cache: rule a: duplicate name")
+}
+
+func TestRulesStrategy(t *testing.T) {
+	rules, err := ParseRules([]byte(testRulesYAML))
+	require.NoError(t, err)
+
+	secondary := persist.NewMemoryStore(time.Minute)
+	strategy, err := rules.Compile(map[string]persist.CacheStore{"secondary": secondary})
+	require.NoError(t, err)
+
+	resolve := func(route string, req *http.Request) (bool, Strategy) {
+		var shouldCache bool
+		var s Strategy
+
+		engine := gin.New()
+		engine.Handle(req.Method, route, func(c *gin.Context) {
+			shouldCache, s = strategy(c)
+		})
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+		return shouldCache, s
+	}
+
+	shouldCache, s := resolve("/products/:id", httptest.NewRequest(http.MethodGet, "/products/42?currency=EUR&page=1", nil))
+	assert.True(t, shouldCache)
+	assert.Equal(t, "rule:products|route:/products/:id|params:id=42|query:currency=EUR", s.CacheKey)
+	assert.Equal(t, 5*time.Minute, s.CacheDuration)
+	assert.Equal(t, secondary, s.CacheStore)
+	assert.Equal(t, []int{200, 404}, s.CacheableStatuses)
+
+	// the first matching rule wins
+	shouldCache, _ = resolve("/products/:id", httptest.NewRequest(http.MethodGet, "/products/42?preview=1", nil))
+	assert.False(t, shouldCache)
+
+	noCache := httptest.NewRequest(http.MethodGet, "/products/42", nil)
+	noCache.Header.Set("Cache-Control", "no-cache")
+	shouldCache, _ = resolve("/products/:id", noCache)
+	assert.False(t, shouldCache)
+
+	withSession := httptest.NewRequest(http.MethodGet, "/products/42", nil)
+	withSession.AddCookie(&http.Cookie{Name: "session", Value: "s"})
+	shouldCache, _ = resolve("/products/:id", withSession)
+	assert.False(t, shouldCache)
+
+	shouldCache, _ = resolve("/products/:id", httptest.NewRequest(http.MethodPost, "/products/42", nil))
+	assert.False(t, shouldCache)
+
+	search := httptest.NewRequest(http.MethodGet, "/search/books?q=go&utm_source=x", nil)
+	search.Header.Set("X-Tenant", "acme")
+	shouldCache, s = resolve("/search/*query", search)
+	assert.True(t, shouldCache)
+	assert.Equal(t, "rule:search|path:/search/books|query:q=go|header:X-Tenant=acme", s.CacheKey)
+	assert.Equal(t, 30*time.Second, s.CacheDuration)
+	assert.Nil(t, s.CacheStore)
+
+	search.Header.Set("X-Tenant", "ACME")
+	shouldCache, _ = resolve("/search/*query", search)
+	assert.False(t, shouldCache)
+
+	shouldCache, _ = resolve("/other", httptest.NewRequest(http.MethodGet, "/other", nil))
+	assert.False(t, shouldCache)
+}
+
+func TestRulesCacheableStatuses(t *testing.T) {
+	rules, err := ParseRules([]byte(`
+rules:
+  - route: /items/:id
+    statuses: [200, 404]
+`))
+	require.NoError(t, err)
+	strategy, err := rules.Compile(nil)
+	require.NoError(t, err)
+
+	calls := 0
+	engine := gin.New()
+	engine.GET("/items/:id",
+		Cache(persist.NewMemoryStore(time.Minute), time.Minute, WithCacheStrategyByRequest(strategy)),
+		func(c *gin.Context) {
+			calls++
+			c.String(http.StatusNotFound, "not found")
+		},
+	)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items/1", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+	assert.Equal(t, 1, calls)
+}
+
+func TestRulesOverlappingKeys(t *testing.T) {
+	rules, err := ParseRules([]byte(`
+rules:
+  - name: mobile
+    path_regex: ^/items/
+    headers: {X-Client: mobile}
+  - path_regex: ^/items/
+    methods: [GET, HEAD]
+`))
+	require.NoError(t, err)
+	strategy, err := rules.Compile(nil)
+	require.NoError(t, err)
+
+	calls := 0
+	cacheMiddleware := Cache(persist.NewMemoryStore(time.Minute), time.Minute, WithCacheStrategyByRequest(strategy))
+	handler := func(c *gin.Context) {
+		calls++
+		c.String(http.StatusOK, "%s %s", c.GetHeader("X-Client"), c.Request.Method)
+	}
+	engine := gin.New()
+	engine.GET("/items/:id", cacheMiddleware, handler)
+	engine.HEAD("/items/:id", cacheMiddleware, handler)
+
+	serve := func(method, client string) string {
+		req := httptest.NewRequest(method, "/items/1", nil)
+		if client != "" {
+			req.Header.Set("X-Client", client)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	// the rules cache the same path in their own entries
+	assert.Equal(t, "mobile GET", serve(http.MethodGet, "mobile"))
+	assert.Equal(t, " GET", serve(http.MethodGet, ""))
+	assert.Equal(t, "mobile GET", serve(http.MethodGet, "mobile"))
+	assert.Equal(t, " GET", serve(http.MethodGet, ""))
+	assert.Equal(t, 2, calls)
+
+	// the methods of a rule don't share an entry
+	serve(http.MethodHead, "")
+	assert.Equal(t, 3, calls)
+
+	var keys []string
+	keyEngine := gin.New()
+	keyEngine.Handle(http.MethodHead, "/items/:id", func(c *gin.Context) {
+		_, s := strategy(c)
+		keys = append(keys, s.CacheKey)
+	})
+	keyEngine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodHead, "/items/1", nil))
+	assert.Equal(t, []string{"rule:1|HEAD|path:/items/1|query:"}, keys)
+}
+
+func TestRulesKeyRecipePath(t *testing.T) {
+	rules, err := ParseRules([]byte(`
+rules:
+  - name: prices
+    route: /prices/:id
+    key: {query: [currency]}
+  - name: docs
+    path_regex: ^/docs/
+    key: {headers: [Accept]}
+  - name: users
+    route: /users/:id
+    key: {route: true}
+`))
+	require.NoError(t, err)
+	strategy, err := rules.Compile(nil)
+	require.NoError(t, err)
+
+	cacheKey := func(route, uri string) string {
+		var key string
+		engine := gin.New()
+		engine.GET(route, func(c *gin.Context) {
+			_, s := strategy(c)
+			key = s.CacheKey
+		})
+		req := httptest.NewRequest(http.MethodGet, uri, nil)
+		req.Header.Set("Accept", "text/html")
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+		return key
+	}
+
+	// the recipes leaving out the path still keep the paths of the rule apart
+	assert.Equal(t, "rule:prices|path:/prices/1|query:currency=EUR", cacheKey("/prices/:id", "/prices/1?currency=EUR"))
+	assert.NotEqual(t, cacheKey("/prices/:id", "/prices/1?currency=EUR"), cacheKey("/prices/:id", "/prices/2?currency=EUR"))
+	assert.NotEqual(t, cacheKey("/docs/*page", "/docs/a"), cacheKey("/docs/*page", "/docs/b"))
+	assert.Equal(t, "rule:users|route:/users/:id|path:/users/42", cacheKey("/users/:id", "/users/42"))
+}
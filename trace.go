@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	spanLookup       = "gin-cache.lookup"
+	spanSingleFlight = "gin-cache.singleflight"
+	spanFill         = "gin-cache.fill"
+	spanStoreSet     = "gin-cache.store.set"
+	spanStoreDelete  = "gin-cache.store.delete"
+
+	tracerName = "github.com/chenyahui/gin-cache"
+
+	attrKeyHash      = attribute.Key("gin_cache.key_hash")
+	attrStore        = attribute.Key("gin_cache.store")
+	attrHit          = attribute.Key("gin_cache.hit")
+	attrEntrySize    = attribute.Key("gin_cache.entry_size")
+	attrShared       = attribute.Key("gin_cache.singleflight.shared")
+	attrStatus       = attribute.Key("gin_cache.status")
+	attrStoreSkipped = attribute.Key("gin_cache.store_skipped")
+)
+
+// startSpan starts a span as the child of the span in c.Request.Context(), it's a noop without WithTracer
+func startSpan(c *gin.Context, cfg *Config, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx := c.Request.Context()
+	if cfg.tracer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	return cfg.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on span unless it's a cache miss, then ends span
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, persist.ErrCacheMiss) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// deleteWithSpan deletes key from store in a span, the child of the span in ctx, it's used by the event handlers
+// which don't know the tracer of the middleware, e.g. PrivateCache. The tracer comes from the provider of the parent,
+// which is the tracer of WithTracer during the fill, so it's a noop unless the parent span is recording.
+func deleteWithSpan(ctx context.Context, store persist.CacheStore, key string) error {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.IsRecording() {
+		return store.Delete(key)
+	}
+
+	_, span := parent.TracerProvider().Tracer(tracerName).Start(ctx, spanStoreDelete,
+		trace.WithAttributes(spanAttributes(store, key)...))
+	err := store.Delete(key)
+	endSpan(span, err)
+	return err
+}
+
+// spanAttributes identify the entry without leaking the cache key, which may contain query data
+func spanAttributes(cacheStore persist.CacheStore, cacheKey string) []attribute.KeyValue {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(cacheKey))
+
+	return []attribute.KeyValue{
+		attrKeyHash.String(strconv.FormatUint(h.Sum64(), 16)),
+		attrStore.String(fmt.Sprintf("%T", cacheStore)),
+	}
+}
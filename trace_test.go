@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chenyahui/gin-cache/persist"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func findSpan(spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	engine := gin.New()
+	engine.GET("/cache",
+		CacheByRequestURI(persist.NewMemoryStore(1*time.Minute), 3*time.Second, WithTracer(tracer)),
+		func(c *gin.Context) {
+			_, span := tracer.Start(c.Request.Context(), "handler")
+			defer span.End()
+			c.String(http.StatusOK, "value")
+		},
+	)
+
+	serve := func() trace.SpanContext {
+		ctx, root := tracer.Start(context.Background(), "root")
+		defer root.End()
+
+		req := httptest.NewRequest(http.MethodGet, "/cache?uid=1", nil).WithContext(ctx)
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+		return root.SpanContext()
+	}
+
+	// miss
+	root := serve()
+	spans := recorder.Ended()
+
+	lookup := findSpan(spans, spanLookup)
+	require.NotNil(t, lookup)
+	assert.Equal(t, root.SpanID(), lookup.Parent().SpanID())
+	assert.False(t, spanAttribute(lookup, attrHit).AsBool())
+	assert.Equal(t, "*persist.MemoryStore", spanAttribute(lookup, attrStore).AsString())
+	assert.NotEqual(t, "/cache?uid=1", spanAttribute(lookup, attrKeyHash).AsString())
+
+	singleFlight := findSpan(spans, spanSingleFlight)
+	require.NotNil(t, singleFlight)
+	assert.False(t, spanAttribute(singleFlight, attrShared).AsBool())
+
+	fill := findSpan(spans, spanFill)
+	require.NotNil(t, fill)
+	assert.Equal(t, singleFlight.SpanContext().SpanID(), fill.Parent().SpanID())
+	assert.Equal(t, int64(http.StatusOK), spanAttribute(fill, attrStatus).AsInt64())
+
+	handler := findSpan(spans, "handler")
+	require.NotNil(t, handler)
+	assert.Equal(t, fill.SpanContext().SpanID(), handler.Parent().SpanID())
+
+	set := findSpan(spans, spanStoreSet)
+	require.NotNil(t, set)
+	assert.Equal(t, fill.SpanContext().SpanID(), set.Parent().SpanID())
+	assert.Equal(t, int64(len("value")), spanAttribute(set, attrEntrySize).AsInt64())
+
+	// hit
+	serve()
+	spans = recorder.Ended()
+	hitLookup := spans[len(spans)-2]
+	assert.Equal(t, spanLookup, hitLookup.Name())
+	assert.True(t, spanAttribute(hitLookup, attrHit).AsBool())
+	assert.Equal(t, int64(len("value")), spanAttribute(hitLookup, attrEntrySize).AsInt64())
+}
+
+func TestTracerStoreDelete(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	memoryStore := persist.NewMemoryStore(1 * time.Minute)
+	privateCache := NewPrivateCache(memoryStore, PrincipalFromHeader("X-Tenant"), WithPrincipalQuota(1))
+	engine := gin.New()
+	engine.GET("/items/:id",
+		CacheByRequestURI(memoryStore, 3*time.Second,
+			WithTracer(tracer), WithPrivateCache(privateCache)),
+		func(c *gin.Context) {
+			c.String(http.StatusOK, "item")
+		},
+	)
+
+	for _, uri := range []string{"/items/1", "/items/2"} {
+		req := httptest.NewRequest(http.MethodGet, uri, nil)
+		req.Header.Set("X-Tenant", "acme")
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+		time.Sleep(time.Millisecond)
+	}
+
+	// the entry beyond the quota is deleted in a span of the fill
+	spans := recorder.Ended()
+	deleteSpan := findSpan(spans, spanStoreDelete)
+	require.NotNil(t, deleteSpan)
+	assert.Equal(t, "*persist.MemoryStore", spanAttribute(deleteSpan, attrStore).AsString())
+
+	var fillSpanIDs []trace.SpanID
+	for _, span := range spans {
+		if span.Name() == spanFill {
+			fillSpanIDs = append(fillSpanIDs, span.SpanContext().SpanID())
+		}
+	}
+	assert.Contains(t, fillSpanIDs, deleteSpan.Parent().SpanID())
+}